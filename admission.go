@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManyConns  = errors.New("websocket: too many connections")
+	ErrHandshakeRate = errors.New("websocket: handshake rate exceeded")
+)
+
+// AdmissionError is returned when the handshake was rejected by [AdmissionConfig],
+// RetryAfter is sent to the client in the Retry-After header.
+type AdmissionError struct {
+	// Err is either [ErrTooManyConns] or [ErrHandshakeRate]
+	Err        error
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err, e.RetryAfter)
+}
+
+func (e *AdmissionError) Unwrap() error {
+	return e.Err
+}
+
+// retryAfter returns the Retry-After header value in seconds.
+func (e *AdmissionError) retryAfter() string {
+	return strconv.FormatInt(int64(math.Ceil(e.RetryAfter.Seconds())), 10)
+}
+
+// AdmissionConfig is used to limit the connections and handshakes accepted by an [Upgrader],
+// limits that aren't assigned are disabled.
+//
+// Clients are identified by their remote IP address masked to IPv4PrefixLen or IPv6PrefixLen bits.
+// An accepted connection holds its slot until it's closed.
+type AdmissionConfig struct {
+	// MaxConns is the maximum number of concurrent connections,
+	// handshakes over the limit are rejected with 503 Service Unavailable.
+	MaxConns int
+
+	// MaxConnsPerIP is the maximum number of concurrent connections per client,
+	// handshakes over the limit are rejected with 429 Too Many Requests.
+	MaxConnsPerIP int
+
+	// HandshakeRate is the number of handshakes allowed per second per client with bursts of HandshakeBurst,
+	// handshakes over the limit are rejected with 429 Too Many Requests.
+	HandshakeRate  float64
+	HandshakeBurst int
+
+	// IPv4PrefixLen and IPv6PrefixLen are the prefixes that identify a client,
+	// if not assigned the defaults are 32 and 64.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	// RetryAfter is sent when a connection limit is reached,
+	// if not assigned the default is 1 second.
+	RetryAfter time.Duration
+
+	// ClientIP returns the client address, use it when behind a trusted reverse proxy,
+	// if not assigned the address from [http.Request.RemoteAddr] is used.
+	ClientIP func(r *http.Request) (netip.Addr, error)
+}
+
+func (cfg *AdmissionConfig) isEnabled() bool {
+	return cfg.MaxConns > 0 || cfg.MaxConnsPerIP > 0 || cfg.HandshakeRate > 0
+}
+
+// admission tracks the state of [AdmissionConfig] limits.
+type admission struct {
+	mu        sync.Mutex
+	conns     int
+	clients   map[netip.Prefix]*clientState
+	lastSweep time.Time
+}
+
+type clientState struct {
+	conns  int
+	bucket tokenBucket
+}
+
+// sweepInterval is how often idle clients are removed.
+const sweepInterval = time.Minute
+
+// clientPrefix returns the prefix identifying the client of r.
+func (cfg *AdmissionConfig) clientPrefix(r *http.Request) (netip.Prefix, error) {
+	var addr netip.Addr
+	var err error
+	if cfg.ClientIP != nil {
+		addr, err = cfg.ClientIP(r)
+	} else {
+		host, _, serr := net.SplitHostPort(r.RemoteAddr)
+		if serr != nil {
+			host = r.RemoteAddr
+		}
+		addr, err = netip.ParseAddr(host)
+	}
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	addr = addr.Unmap().WithZone("")
+	bits := cfg.IPv6PrefixLen
+	if bits <= 0 {
+		bits = 64
+	}
+	if addr.Is4() {
+		bits = cfg.IPv4PrefixLen
+		if bits <= 0 {
+			bits = 32
+		}
+	}
+	return addr.Prefix(min(bits, addr.BitLen()))
+}
+
+// admit checks the limits for r and takes a connection slot,
+// release MUST be called once when the connection is closed or the handshake fails.
+func (a *admission) admit(cfg *AdmissionConfig, r *http.Request) (release func(), err error) {
+	prefix, err := cfg.clientPrefix(r)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: invalid client address: %w", err)
+	}
+	retryAfter := cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.sweep(cfg, now)
+
+	if cfg.MaxConns > 0 && a.conns >= cfg.MaxConns {
+		return nil, &AdmissionError{Err: ErrTooManyConns, StatusCode: http.StatusServiceUnavailable, RetryAfter: retryAfter}
+	}
+
+	if a.clients == nil {
+		a.clients = make(map[netip.Prefix]*clientState)
+	}
+	client := a.clients[prefix]
+	if client == nil {
+		client = &clientState{bucket: newTokenBucket(cfg.HandshakeRate, cfg.HandshakeBurst)}
+		a.clients[prefix] = client
+	}
+
+	if cfg.HandshakeRate > 0 {
+		ok, wait := client.bucket.take(now, 1)
+		if !ok {
+			return nil, &AdmissionError{Err: ErrHandshakeRate, StatusCode: http.StatusTooManyRequests, RetryAfter: wait}
+		}
+	}
+	if cfg.MaxConnsPerIP > 0 && client.conns >= cfg.MaxConnsPerIP {
+		return nil, &AdmissionError{Err: ErrTooManyConns, StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}
+	}
+
+	a.conns++
+	client.conns++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			a.conns--
+			client.conns--
+			a.mu.Unlock()
+		})
+	}, nil
+}
+
+// sweep removes the clients with no connections and a full bucket, a.mu MUST be held.
+func (a *admission) sweep(cfg *AdmissionConfig, now time.Time) {
+	if now.Sub(a.lastSweep) < sweepInterval {
+		return
+	}
+	a.lastSweep = now
+	for prefix, client := range a.clients {
+		if client.conns == 0 && (cfg.HandshakeRate <= 0 || client.bucket.isFull(now)) {
+			delete(a.clients, prefix)
+		}
+	}
+}
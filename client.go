@@ -2,32 +2,111 @@ package websocket
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 )
 
 var (
 	ErrBadURL           = errors.New("websocket: malformed ws URL")
 	ErrDuplicateHeaders = errors.New("websocket: duplicate headers aren't allowed")
 	ErrHandshake        = errors.New("websocket: error negotiating handshake with peer")
+	ErrRedirect         = errors.New("websocket: redirect not followed")
 )
 
+// maxHandshakeErrorBody is the maximum number of bytes read from a failed handshake response body.
+const maxHandshakeErrorBody = 64 << 10
+
+// HandshakeError is returned by [Dialer.Dial] when the server's handshake response fails validation.
+//
+// It wraps [ErrHandshake] so it can be checked with errors.Is.
+type HandshakeError struct {
+	// Reason describes why the handshake response was rejected.
+	Reason string
+
+	// Response is the server's handshake response, use it to check the status code
+	// and headers like Retry-After or WWW-Authenticate.
+	//
+	// The Body is read up to 64KB and can be read after the connection is closed.
+	Response *http.Response
+}
+
+func (e *HandshakeError) Error() string {
+	return ErrHandshake.Error() + ": " + e.Reason
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return ErrHandshake
+}
+
+// StatusCode returns the status code of the handshake response or 0 if there's no response.
+func (e *HandshakeError) StatusCode() int {
+	if e.Response == nil {
+		return 0
+	}
+	return e.Response.StatusCode
+}
+
+func handshakeErr(format string, a ...any) *HandshakeError {
+	return &HandshakeError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// setResponse attaches the response to the error,
+// and buffers a bounded part of the body so it's readable after the connection is closed.
+func (e *HandshakeError) setResponse(res *http.Response) {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxHandshakeErrorBody))
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	e.Response = res
+}
+
 type Dialer struct {
 	// ReadBufferSize used for size when making bufio read buffers,
 	// if not assigned the default buffer size is 4KB.
 	ReadBufferSize int
 
+	// WriteBufferSize enables coalescing frames into a single write when assigned,
+	// buffered frames are sent on [Conn.Flush], when the buffer is full or after WriteFlushInterval.
+	// Control frames are always written immediately.
+	WriteBufferSize int
+
+	// WriteFlushInterval is the maximum time a frame stays in the write buffer,
+	// if not assigned frames are only sent when the buffer is full or on [Conn.Flush].
+	WriteFlushInterval time.Duration
+
 	// Subprotocols is the client's supported protocols in order of prefernce.
 	// if no Subprotocols is specified then no protocol is negotiated during handshake.
 	Subprotocols []string
 
-	// TlsConfig used when connecting to a secure websocket connection (eg. wss)
+	// TlsConfig used when connecting to a secure websocket connection (eg. wss),
+	// if ServerName isn't set the host from the url is used.
 	TlsConfig *tls.Config
 
+	// NetDialContext is used to dial the TCP connection (or unix socket for ws+unix and wss+unix urls),
+	// if not assigned a [net.Dialer] is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Proxy returns the proxy for the handshake request, the request's URL has an http or https scheme
+	// so [http.ProxyFromEnvironment] can be used directly.
+	// HTTP and HTTPS proxies are used with HTTP CONNECT, socks5 and socks5h proxies are also supported
+	// (socks5 resolves the host name locally, socks5h sends it to the proxy),
+	// credentials in the proxy url are used for authentication.
+	// if not assigned or it returns a nil url the connection is dialed directly.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// HandshakeTimeout is the maximum duration for dialing and completing the handshake,
+	// if not assigned the handshake is only bounded by the context passed to [Dialer.DialContext].
+	HandshakeTimeout time.Duration
+
 	// Headers to be sent during initial handshake,
 	// headers MUST NOT include any websocket reserved headers.
 	Headers http.Header
@@ -35,9 +114,34 @@ type Dialer struct {
 	// enableCompression is wether to negotiate per-message deflate extension or not.
 	CompressionConfig CompressionConfig
 
+	// SendQueue configures the queue used by [Conn.Enqueue].
+	SendQueue SendQueueConfig
+
+	// InboundLimit limits the rate of messages and frames received from the peer.
+	InboundLimit InboundLimitConfig
+
 	// CookieJar used to hold cookies to be sent during the initial handshake
-	// like cookies for auth (sessions, JWT's, ...)
+	// like cookies for auth (sessions, JWT's, ...),
+	// cookies set by the server in the handshake response are stored back in the jar.
 	CookieJar http.CookieJar
+
+	// MaxRedirects is the maximum number of redirects (301, 302, 303, 307 and 308) followed during the handshake,
+	// if not assigned redirects aren't followed and the handshake fails with a [*HandshakeError].
+	// Redirects from wss to ws are never followed, credentials in Headers (Authorization and Cookie)
+	// aren't sent after a redirect to another host.
+	MaxRedirects int
+
+	// CheckRedirect is called before following a redirect with the next request and the requests made so far,
+	// returning an error stops following the redirect and it's returned by [Dialer.Dial].
+	// Headers set on the request aren't used, it's for inspection only.
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+
+	// LenientHandshake disables the strict validation of the server's handshake response.
+	//
+	// By default the handshake fails if the server selects a subprotocol that wasn't offered,
+	// responds with an extension that wasn't requested or with permessage-deflate
+	// parameters that don't match the offer.
+	LenientHandshake bool
 }
 
 // Dial is helper function that creates a [Dialer] and dials the websocket connection
@@ -49,34 +153,183 @@ func Dial(urlStr string) (*Conn, *http.Response, error) {
 // Dial connects to the weboscket url handles the handshake and returns a [*Conn] representing
 // a websocket connection or an error if the handshake fails.
 //
-// Dial also returns the http response from the handshake if you want to do something with it.
+// Dial also returns the http response from the handshake if you want to do something with it,
+// if the server rejects the handshake the response is also returned with a [*HandshakeError].
+//
+// Dial uses [context.Background], see [Dialer.DialContext].
 func (d *Dialer) Dial(urlStr string) (*Conn, *http.Response, error) {
-	u, err := url.Parse(urlStr)
+	return d.DialContext(context.Background(), urlStr)
+}
+
+// DialContext is same as [Dialer.Dial] except the context is used for dialing and
+// completing the handshake, once the connection is established the context doesn't affect it.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string) (*Conn, *http.Response, error) {
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	u, socket, err := parseURL(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// credentials are only sent to the host the caller dialed
+	origHost := hostPort(u)
+	var via []*http.Request
+	for {
+		conn, res, err := d.handshake(ctx, u, socket, hostPort(u) != origHost)
+		if err == nil {
+			return conn, res, nil
+		}
+
+		// follow redirects
+		next, ok := redirectURL(res, err)
+		if !ok || d.MaxRedirects <= 0 {
+			return nil, res, err
+		}
+		if next == nil {
+			return nil, res, ErrBadURL
+		}
+		via = append(via, res.Request)
+		err = d.checkRedirect(next, via, hostPort(next) != origHost)
+		if err != nil {
+			return nil, res, err
+		}
+		// relative redirects stay on the same unix socket
+		if next.Host != u.Host {
+			socket = ""
+		}
+		u = next
+	}
+}
+
+// NewClientConn does the client handshake over a connection the caller already established,
+// like a connection from a custom transport or an already set up TLS connection.
+//
+// The url is only used to build the handshake request, options related to dialing
+// (Proxy, NetDialContext, TlsConfig and redirects) aren't used,
+// if d is nil the default [Dialer] options are used.
+//
+// netConn is closed if the handshake fails.
+func NewClientConn(netConn net.Conn, urlStr string, d *Dialer) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &Dialer{}
+	}
+
+	ctx := context.Background()
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	u, _, err := parseURL(urlStr)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	req, keyHash, err := d.newRequest(u, false)
 	if err != nil {
+		netConn.Close()
 		return nil, nil, err
 	}
 
+	return d.clientHandshake(ctx, netConn, req, keyHash)
+}
+
+// parseURL validates the websocket url and converts its scheme to the http equivalent,
+// for ws+unix and wss+unix urls it also returns the unix socket path.
+//
+// Unix socket urls have the form ws+unix:///path/to/socket:/request/path?query,
+// the request path defaults to "/" and the Host header is "localhost".
+func parseURL(urlStr string) (*url.URL, string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// username and password aren't allowed in websocket url
 	if u.User != nil {
-		return nil, nil, ErrBadURL
+		return nil, "", ErrBadURL
 	}
 
 	// convert scheme to http equivalent
+	isUnix := false
 	switch u.Scheme {
 	case "ws":
 		u.Scheme = "http"
 	case "wss":
 		u.Scheme = "https"
+	case "ws+unix":
+		u.Scheme = "http"
+		isUnix = true
+	case "wss+unix":
+		u.Scheme = "https"
+		isUnix = true
 	default:
-		return nil, nil, ErrBadURL
+		return nil, "", ErrBadURL
+	}
+
+	if !isUnix {
+		return u, "", nil
+	}
+
+	// split the socket path from the request path
+	if u.Host != "" {
+		return nil, "", ErrBadURL
+	}
+	socket, path, _ := strings.Cut(u.Path, ":")
+	if socket == "" {
+		return nil, "", ErrBadURL
+	}
+	if path == "" {
+		path = "/"
+	}
+	u.Path = path
+	u.RawPath = ""
+	u.Host = "localhost"
+
+	return u, socket, nil
+}
+
+// handshake dials the http(s) url and does the websocket handshake,
+// socket is the unix socket path to dial or empty to dial over TCP,
+// stripAuth drops the credentials in [Dialer.Headers] after a redirect to another host.
+func (d *Dialer) handshake(ctx context.Context, u *url.URL, socket string, stripAuth bool) (*Conn, *http.Response, error) {
+	req, keyHash, err := d.newRequest(u, stripAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var proxyURL *url.URL
+	if d.Proxy != nil && socket == "" {
+		proxyURL, err = d.Proxy(req)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
+	// dial url
+	netConn, err := d.netDial(ctx, u, proxyURL, socket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return d.clientHandshake(ctx, netConn, req, keyHash)
+}
+
+// newRequest builds the handshake request for the url,
+// it also returns the expected Sec-WebSocket-Accept value.
+// sensitive headers aren't copied from [Dialer.Headers] if stripAuth is true.
+func (d *Dialer) newRequest(u *url.URL, stripAuth bool) (*http.Request, string, error) {
 	// challange key and hash
 	key := makeKey()
 	keyHash := makeKeyHash(key)
 
 	// http request
-	req := http.Request{
+	req := &http.Request{
 		Method:     http.MethodGet,
 		URL:        u,
 		Proto:      "HTTP/1.1",
@@ -97,10 +350,13 @@ func (d *Dialer) Dial(urlStr string) (*Conn, *http.Response, error) {
 			"Sec-WebSocket-Extensions",
 			"Sec-WebSocket-Protocol",
 		}) {
-			return nil, nil, ErrDuplicateHeaders
+			return nil, "", ErrDuplicateHeaders
 		}
 
 		for k, v := range d.Headers {
+			if stripAuth && isSensitiveHeader(k) {
+				continue
+			}
 			req.Header[k] = v
 		}
 	}
@@ -125,11 +381,12 @@ func (d *Dialer) Dial(urlStr string) (*Conn, *http.Response, error) {
 		}
 	}
 
-	// dial url
-	netConn, err := d.netDial(u)
-	if err != nil {
-		return nil, nil, err
-	}
+	return req, keyHash, nil
+}
+
+// clientHandshake does the websocket handshake over an established connection,
+// netConn is closed if the handshake fails.
+func (d *Dialer) clientHandshake(ctx context.Context, netConn net.Conn, req *http.Request, keyHash string) (*Conn, *http.Response, error) {
 	// Clean connection if error happens
 	defer func() {
 		if netConn != nil {
@@ -138,9 +395,16 @@ func (d *Dialer) Dial(urlStr string) (*Conn, *http.Response, error) {
 		}
 	}()
 
+	// bound the handshake by the context
+	stop := watchContext(ctx, netConn)
+	defer stop()
+
 	// write handshake
-	err = req.Write(netConn)
+	err := req.Write(netConn)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
 		return nil, nil, err
 	}
 
@@ -152,35 +416,28 @@ func (d *Dialer) Dial(urlStr string) (*Conn, *http.Response, error) {
 		// default size is 4KB
 		br = bufio.NewReader(netConn)
 	}
-	res, err := http.ReadResponse(br, &req)
+	res, err := http.ReadResponse(br, req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
 		return nil, nil, err
 	}
 
-	// Check for main required headers
-	if res.StatusCode != 101 ||
-		!checkHeaderValue(res.Header, "Upgrade", "websocket") ||
-		!checkHeaderValue(res.Header, "Connection", "Upgrade") ||
-		res.Header.Get("Sec-WebSocket-Accept") != keyHash {
-		return nil, nil, ErrHandshake
-	}
-
-	// if header exits, it indicates that's the server
-	// doesn't support our websocket version.
-	resVersion := res.Header.Get("Sec-WebSocket-Version")
-	if resVersion != "" {
-		return nil, nil, ErrHandshake
+	// store cookies set during the handshake
+	if d.CookieJar != nil {
+		if cookies := res.Cookies(); len(cookies) > 0 {
+			d.CookieJar.SetCookies(req.URL, cookies)
+		}
 	}
 
-	// subprotocol
-	subprotocol := res.Header.Get("Sec-WebSocket-Protocol")
-	if len(d.Subprotocols) == 0 && subprotocol != "" {
-		return nil, nil, ErrHandshake
+	subprotocol, exts, herr := d.checkResponse(res, keyHash)
+	if herr != nil {
+		herr.setResponse(res)
+		return nil, res, herr
 	}
 
-	// extension
-	exts := parseExtHeader(res.Header)
-	isFlate, _, isClientNoTakeover := isFlateIsTakeover(exts)
+	isFlate, _, isClientNoTakeover := isFlateIsTakeover(exts, true)
 	cc := &CompressionConfig{
 		Enabled:              d.CompressionConfig.Enabled,
 		IsContextTakeover:    d.CompressionConfig.IsContextTakeover,
@@ -195,32 +452,275 @@ func (d *Dialer) Dial(urlStr string) (*Conn, *http.Response, error) {
 		cc.IsContextTakeover = false
 	}
 
-	conn := newConn(netConn, br, cc, subprotocol, false)
+	// the context only applies to the handshake
+	if !stop() {
+		return nil, nil, ctx.Err()
+	}
+	netConn.SetDeadline(time.Time{})
+
+	var negotiated []Extension
+	if cc.Enabled {
+		negotiated = toExtensions(exts)
+	}
+	conn := d.newClientConn(context.WithoutCancel(ctx), netConn, br, cc, subprotocol, negotiated, req)
 
 	// Unset netConn
 	netConn = nil
 	return conn, res, nil
 }
 
-func (d *Dialer) netDial(u *url.URL) (net.Conn, error) {
-	var dialURL string
-	// add hostname
-	dialURL += u.Hostname()
-	// add port
-	dialURL += ":"
+// newClientConn creates the client [*Conn] after a successful handshake request req.
+func (d *Dialer) newClientConn(ctx context.Context, netConn net.Conn, br *bufio.Reader, cc *CompressionConfig, subprotocol string, exts []Extension, req *http.Request) *Conn {
+	conn := newConn(ctx, netConn, br, cc, subprotocol, false)
+	conn.extensions = exts
+	conn.request = req
+	conn.sendQueueConfig = d.SendQueue
+	conn.limiter = newInboundLimiter(d.InboundLimit)
+	conn.enableWriteBuffer(d.WriteBufferSize, d.WriteFlushInterval)
+	return conn
+}
+
+// redirectURL returns the url the server redirected the handshake to,
+// ok is false if the response isn't a redirect and next is nil if the location is invalid.
+func redirectURL(res *http.Response, err error) (next *url.URL, ok bool) {
+	var herr *HandshakeError
+	if res == nil || !errors.As(err, &herr) {
+		return nil, false
+	}
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, false
+	}
+
+	next, err = res.Location()
+	if err != nil || next.User != nil {
+		return nil, true
+	}
+	next.Fragment = ""
+	switch next.Scheme {
+	case "ws", "http":
+		next.Scheme = "http"
+	case "wss", "https":
+		next.Scheme = "https"
+	default:
+		return nil, true
+	}
+
+	return next, true
+}
+
+// checkRedirect applies the redirect policy before following a redirect to next,
+// via holds the requests made so far with the most recent one last.
+func (d *Dialer) checkRedirect(next *url.URL, via []*http.Request, stripAuth bool) error {
+	if len(via) > d.MaxRedirects {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrRedirect, d.MaxRedirects)
+	}
+	// never downgrade a secure connection
+	prev := via[len(via)-1]
+	if prev.URL.Scheme == "https" && next.Scheme != "https" {
+		return fmt.Errorf("%w: insecure redirect to %s", ErrRedirect, next.Redacted())
+	}
+
+	if d.CheckRedirect != nil {
+		req := &http.Request{
+			Method:     http.MethodGet,
+			URL:        next,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     prev.Header.Clone(),
+			Host:       next.Host,
+		}
+		if stripAuth {
+			for k := range req.Header {
+				if isSensitiveHeader(k) {
+					delete(req.Header, k)
+				}
+			}
+		}
+		return d.CheckRedirect(req, via)
+	}
+
+	return nil
+}
+
+// isSensitiveHeader reports whether the header carries credentials,
+// like net/http these aren't sent after a redirect to another host.
+func isSensitiveHeader(key string) bool {
+	switch http.CanonicalHeaderKey(key) {
+	case "Authorization", "Www-Authenticate", "Cookie", "Cookie2":
+		return true
+	}
+	return false
+}
+
+// checkResponse validates the server's handshake response,
+// and returns the selected subprotocol and the accepted extensions.
+func (d *Dialer) checkResponse(res *http.Response, keyHash string) (string, []extension, *HandshakeError) {
+	// Check for main required headers
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return "", nil, handshakeErr("unexpected status code %d", res.StatusCode)
+	}
+	if !checkHeaderValue(res.Header, "Upgrade", "websocket") {
+		return "", nil, handshakeErr("missing/mismatched Upgrade header")
+	}
+	if !checkHeaderValue(res.Header, "Connection", "Upgrade") {
+		return "", nil, handshakeErr("missing/mismatched Connection header")
+	}
+	if res.Header.Get("Sec-WebSocket-Accept") != keyHash {
+		return "", nil, handshakeErr("mismatched Sec-WebSocket-Accept header")
+	}
+
+	// if header exits, it indicates that's the server
+	// doesn't support our websocket version.
+	resVersion := res.Header.Get("Sec-WebSocket-Version")
+	if resVersion != "" {
+		return "", nil, handshakeErr("server doesn't support version %s, supported versions: %s", VERSION, resVersion)
+	}
+
+	// subprotocol
+	subprotocol := res.Header.Get("Sec-WebSocket-Protocol")
+	if len(d.Subprotocols) == 0 && subprotocol != "" {
+		return "", nil, handshakeErr("server selected subprotocol %q but none were offered", subprotocol)
+	}
+	if !d.LenientHandshake && subprotocol != "" && !slices.Contains(d.Subprotocols, subprotocol) {
+		return "", nil, handshakeErr("server selected subprotocol %q that wasn't offered", subprotocol)
+	}
+
+	// extension
+	exts, err := parseExtHeader(res.Header)
+	if err != nil {
+		return "", nil, handshakeErr("malformed Sec-WebSocket-Extensions header")
+	}
+	if !d.LenientHandshake {
+		herr := d.checkExtensions(exts)
+		if herr != nil {
+			return "", nil, herr
+		}
+	}
+
+	return subprotocol, exts, nil
+}
+
+// checkExtensions validates that the server only accepted the extensions we offered,
+// with parameters that match the offer as defined in RFC 7692 section 7.1.
+func (d *Dialer) checkExtensions(exts []extension) *HandshakeError {
+	if len(exts) == 0 {
+		return nil
+	}
+
+	ext := exts[0]
+	if ext.name != "permessage-deflate" || !d.CompressionConfig.Enabled {
+		return handshakeErr("server accepted extension %q that wasn't offered", ext.name)
+	}
+	if len(exts) > 1 {
+		return handshakeErr("server accepted extension %q that wasn't offered", exts[1].name)
+	}
+
+	seen := make(map[string]bool, len(ext.params))
+	for _, p := range ext.params {
+		if seen[p.name] {
+			return handshakeErr("duplicate permessage-deflate parameter %q", p.name)
+		}
+		seen[p.name] = true
+
+		switch p.name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if p.value != "" {
+				return handshakeErr("permessage-deflate parameter %q must not have a value", p.name)
+			}
+		case "server_max_window_bits":
+			if !isValidWindowBits(p.value) {
+				return handshakeErr("invalid permessage-deflate server_max_window_bits value %q", p.value)
+			}
+		case "client_max_window_bits":
+			// we never offer client_max_window_bits
+			return handshakeErr("permessage-deflate parameter %q wasn't offered", p.name)
+		default:
+			return handshakeErr("unknown permessage-deflate parameter %q", p.name)
+		}
+	}
+
+	return nil
+}
+
+// watchContext applies the context deadline and cancellation to the connection's reads and writes,
+// the returned stop function returns false if the context was already done.
+func watchContext(ctx context.Context, netConn net.Conn) func() bool {
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+	return context.AfterFunc(ctx, func() {
+		// unblock any pending read or write
+		netConn.SetDeadline(time.Unix(1, 0))
+	})
+}
+
+// hostPort returns the host:port address of the url with the scheme's default port.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		}
+	}
+	// JoinHostPort adds the brackets back to IPv6 literals
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (d *Dialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	netDial := d.NetDialContext
+	if netDial == nil {
+		var dialer net.Dialer
+		netDial = dialer.DialContext
+	}
+	return netDial(ctx, network, addr)
+}
+
+func (d *Dialer) netDial(ctx context.Context, u *url.URL, proxyURL *url.URL, socket string) (net.Conn, error) {
+	addr := hostPort(u)
+
+	var netConn net.Conn
+	var err error
 	switch {
-	case u.Port() != "":
-		dialURL += u.Port()
-	case u.Scheme == "http":
-		dialURL += "80"
-	case u.Scheme == "https":
-		dialURL += "433"
+	case socket != "":
+		netConn, err = d.dial(ctx, "unix", socket)
+	case proxyURL != nil:
+		netConn, err = d.dialProxy(ctx, proxyURL, addr)
+	default:
+		netConn, err = d.dial(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// dial the connection
-	if u.Scheme == "https" {
-		return tls.Dial("tcp", dialURL, d.TlsConfig)
+	if u.Scheme != "https" {
+		return netConn, nil
+	}
+
+	// tls handshake
+	var cfg *tls.Config
+	if d.TlsConfig != nil {
+		cfg = d.TlsConfig.Clone()
 	} else {
-		return net.Dial("tcp", dialURL)
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
 	}
+	tlsConn := tls.Client(netConn, cfg)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
 }
@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newWsServer starts a server upgrading requests to /ws, the handshake request headers are sent on headers.
+func newWsServer(t *testing.T, mux *http.ServeMux, headers chan<- http.Header) *httptest.Server {
+	t.Helper()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		headers <- r.Header
+		c.Close()
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func wsURL(srv *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + path
+}
+
+func TestDialRedirect(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	target := newWsServer(t, http.NewServeMux(), headers)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/same", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ws", http.StatusFound)
+	})
+	mux.HandleFunc("/cross", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, wsURL(target, "/ws"), http.StatusTemporaryRedirect)
+	})
+	origin := newWsServer(t, mux, headers)
+
+	tests := []struct {
+		name      string
+		path      string
+		wantCreds bool
+	}{
+		{"same host", "/same", true},
+		{"other host", "/cross", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var via []*http.Request
+			d := &Dialer{
+				MaxRedirects: 1,
+				Headers: http.Header{
+					"Authorization": {"Bearer token"},
+					"Cookie":        {"session=1"},
+					"X-Custom":      {"kept"},
+				},
+				CheckRedirect: func(req *http.Request, v []*http.Request) error {
+					via = v
+					if got := req.Header.Get("Authorization") != ""; got != tt.wantCreds {
+						t.Errorf("CheckRedirect Authorization sent = %v, want %v", got, tt.wantCreds)
+					}
+					return nil
+				},
+			}
+
+			c, _, err := d.Dial(wsURL(origin, tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+
+			if len(via) != 1 || via[0].URL.Path != tt.path {
+				t.Fatalf("via = %v, want the request to %s", via, tt.path)
+			}
+			h := <-headers
+			if h.Get("X-Custom") != "kept" {
+				t.Fatalf("X-Custom = %q, want %q", h.Get("X-Custom"), "kept")
+			}
+			for _, k := range []string{"Authorization", "Cookie"} {
+				if sent := h.Get(k) != ""; sent != tt.wantCreds {
+					t.Fatalf("%s sent = %v, want %v", k, sent, tt.wantCreds)
+				}
+			}
+		})
+	}
+}
+
+func TestDialRedirectLimit(t *testing.T) {
+	var hits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := newWsServer(t, mux, make(chan http.Header, 1))
+
+	// redirects aren't followed by default
+	_, res, err := (&Dialer{}).Dial(wsURL(srv, "/loop"))
+	var herr *HandshakeError
+	if !errors.As(err, &herr) || res == nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("err = %v, want a *HandshakeError with status %d", err, http.StatusFound)
+	}
+
+	hits.Store(0)
+	_, _, err = (&Dialer{MaxRedirects: 3}).Dial(wsURL(srv, "/loop"))
+	if !errors.Is(err, ErrRedirect) {
+		t.Fatalf("err = %v, want %v", err, ErrRedirect)
+	}
+	if n := hits.Load(); n != 4 {
+		t.Fatalf("requests = %d, want 4", n)
+	}
+}
+
+func TestDialRedirectInsecure(t *testing.T) {
+	var hits atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer target.Close()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, wsURL(target, "/ws"), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	d := &Dialer{MaxRedirects: 5, TlsConfig: &tls.Config{InsecureSkipVerify: true}}
+	_, _, err := d.Dial("wss" + strings.TrimPrefix(srv.URL, "https") + "/")
+	if !errors.Is(err, ErrRedirect) {
+		t.Fatalf("err = %v, want %v", err, ErrRedirect)
+	}
+	if hits.Load() != 0 {
+		t.Fatal("followed a redirect from wss to ws")
+	}
+}
+
+func TestDialRedirectCookies(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/ws", http.StatusSeeOther)
+	})
+	srv := newWsServer(t, mux, headers)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := (&Dialer{MaxRedirects: 1, CookieJar: jar}).Dial(wsURL(srv, "/login"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// the cookie set by the redirect response is sent to the next hop
+	if got := (&http.Request{Header: <-headers}); !hasCookie(got.Cookies(), "session", "abc") {
+		t.Fatalf("Cookie = %v, want session=abc", got.Cookies())
+	}
+	u, _ := url.Parse(srv.URL)
+	if !hasCookie(jar.Cookies(u), "session", "abc") {
+		t.Fatalf("jar = %v, want session=abc", jar.Cookies(u))
+	}
+}
+
+func hasCookie(cookies []*http.Cookie, name, value string) bool {
+	for _, c := range cookies {
+		if c.Name == name && c.Value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -2,11 +2,17 @@ package websocket
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"sync"
+	"time"
 	"unicode/utf8"
 )
 
@@ -17,14 +23,47 @@ type Conn struct {
 
 	isServer    bool
 	subprotocol string
+	extensions  []Extension
+	// request is the handshake request, nil for handed off connections
+	request *http.Request
 
 	flatter *flatter
 	cc      *CompressionConfig
 
-	closed bool
+	// wmu serializes frame writes and guards closeSent
+	wmu       sync.Mutex
+	closeSent bool
+	// bw coalesces frames when a write buffer is configured
+	bw            *bufio.Writer
+	flushInterval time.Duration
+	flushTimer    *time.Timer
+
+	// mu guards the connection state below
+	mu      sync.Mutex
+	closed  bool
+	onClose []func()
+	// closeErr is the close frame received from the peer
+	closeErr *CloseError
+
+	inbox     chan Message
+	inboxErr  error
+	outbox    chan Message
+	outboxErr error
+
+	sendQueueConfig SendQueueConfig
+	sq              *sendQueue
+
+	// limiter is nil if no inbound limits are configured
+	limiter *inboundLimiter
+
+	// readAt receives the time every frame payload is read at, only set by NextMessageWithInfo
+	readAt *time.Time
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
 }
 
-func newConn(netConn net.Conn, br *bufio.Reader, cc *CompressionConfig, subprotocol string, isServer bool) *Conn {
+func newConn(ctx context.Context, netConn net.Conn, br *bufio.Reader, cc *CompressionConfig, subprotocol string, isServer bool) *Conn {
 	var flatter *flatter
 	if cc.Enabled {
 		flatter = newFlatter(cc)
@@ -39,7 +78,10 @@ func newConn(netConn net.Conn, br *bufio.Reader, cc *CompressionConfig, subproto
 		}
 	}
 
+	ctx, cancel := context.WithCancelCause(ctx)
 	return &Conn{
+		ctx:         ctx,
+		cancel:      cancel,
 		netConn:     netConn,
 		br:          br,
 		isServer:    isServer,
@@ -53,12 +95,35 @@ var (
 	ErrInvalidMessageType = errors.New("websocket: Specified message must be TextMessage or BinaryMessage")
 	ErrBadMessage         = errors.New("websocket: close 1002 (Protocol violation)")
 	ErrUtf8               = errors.New("websocket: close 1007 (Invalid UTF-8 character)")
+	ErrPolicyViolation    = errors.New("websocket: close 1008 (Policy violation)")
+	ErrCloseSent          = errors.New("websocket: close frame already sent")
 	ErrNormalClose        = errors.New("websocket: close 1000 (Normal)")
 	ErrUnexpectedClose    = errors.New("websocket: Peer disconnected unexpectedly")
 )
 
+// CloseError is returned when the peer closes the connection with a close frame,
+// it wraps [ErrNormalClose] for any status code.
+type CloseError struct {
+	// Code is the status code sent by the peer, [CloseNoStatus] if the frame had none.
+	Code uint16
+	// Text is the close reason sent by the peer.
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d (%s)", e.Code, e.Text)
+}
+
+func (e *CloseError) Unwrap() error {
+	return ErrNormalClose
+}
+
 func (c *Conn) read(n uint64) ([]byte, error) {
 	if n == 0 {
+		c.markRead()
 		return make([]byte, 0), nil
 	}
 
@@ -67,9 +132,17 @@ func (c *Conn) read(n uint64) ([]byte, error) {
 		return buf, err
 	}
 
+	c.markRead()
 	return buf, nil
 }
 
+// markRead records the time a frame payload was read if requested.
+func (c *Conn) markRead() {
+	if c.readAt != nil {
+		*c.readAt = time.Now()
+	}
+}
+
 func (c *Conn) discardRemaining(n int64) (int64, error) {
 	nn, err := io.CopyN(io.Discard, c.br, n)
 	return nn, err
@@ -121,16 +194,13 @@ func (c *Conn) handleBinaryMessage(h *Headers) ([]byte, error) {
 	return payload, nil
 }
 
-func (c *Conn) handleCloseFrame(h *Headers) ([]byte, error) {
+func (c *Conn) handleCloseFrame(h *Headers) (payload []byte, err error) {
 	// close close connection at last
-	defer func() {
-		c.closed = true
-		c.netConn.Close()
-	}()
+	defer func() { c.closeConnCause(err) }()
 	// If no payload then it's a Close with no status or reason
 	if h.PayloadLength == 0 {
 		_, _ = c.sendControl(CloseFrame, CloseNormal, nil)
-		return nil, ErrNormalClose
+		return nil, c.setCloseError(CloseNoStatus, "")
 	}
 	// payload length must be atleast 2 and not bigger than 125 (status code)
 	if h.PayloadLength < 2 || h.PayloadLength > maxControlFramePayloadSize {
@@ -146,7 +216,7 @@ func (c *Conn) handleCloseFrame(h *Headers) ([]byte, error) {
 	}
 
 	// read status code
-	payload, err := c.read(h.PayloadLength)
+	payload, err = c.read(h.PayloadLength)
 	if err != nil {
 		return payload, err
 	}
@@ -176,7 +246,16 @@ func (c *Conn) handleCloseFrame(h *Headers) ([]byte, error) {
 
 	// we don't care if sending the control fails here
 	_, _ = c.sendControl(CloseFrame, statusCode, payload)
-	return payload, ErrNormalClose
+	return payload, c.setCloseError(statusCode, string(payload))
+}
+
+// setCloseError records the close frame received from the peer.
+func (c *Conn) setCloseError(code uint16, text string) error {
+	err := &CloseError{Code: code, Text: text}
+	c.mu.Lock()
+	c.closeErr = err
+	c.mu.Unlock()
+	return err
 }
 
 func (c *Conn) handlePingFrame(h *Headers) ([]byte, error) {
@@ -254,6 +333,7 @@ func (c *Conn) handleSingleFrame(h *Headers) ([]byte, error) {
 func (c *Conn) handleSingleFrameErr(err error) (Opcode, []byte, error) {
 	switch {
 	case isEOF(err):
+		c.closeConnCause(ErrUnexpectedClose)
 		return CloseFrame, nil, ErrUnexpectedClose
 	case errors.Is(err, ErrUtf8):
 		return c.closeWithErr(CloseMistachedPayloadData)
@@ -274,6 +354,33 @@ func (c *Conn) checkRSV1(h *Headers) bool {
 	return false
 }
 
+// nextControlFrame reads and handles a single [PingFrame] or [PongFrame],
+// it's used by the [Poller] to answer pings without waiting for a full message.
+func (c *Conn) nextControlFrame() error {
+	h, err := c.parseFrameHeaders()
+	if err != nil {
+		return err
+	}
+	if h.RSV1 || h.RSV2 || h.RSV3 || h.Mask != c.isServer || !isPingPongFrame(h.Opcode) {
+		return ErrBadMessage
+	}
+	if c.exceedsLimit(h, true) {
+		if c.limiter.action == LimitClose {
+			_, _, err = c.closeWithErr(ClosePolicyViolation)
+			return err
+		}
+		_, err = c.handlePongFrame(h)
+		return err
+	}
+	_, err = c.handleSingleFrame(h)
+	return err
+}
+
+// exceedsLimit reports whether the frame exceeded the inbound limits.
+func (c *Conn) exceedsLimit(h *Headers, isFirst bool) bool {
+	return c.limiter != nil && !c.limiter.allowFrame(h, isFirst)
+}
+
 // NextMessage blocks until it receives a websocket frame of type [TextMessage] or [BinaryMessage],
 //
 // It also handles any control frames in between like [PongFrame],[PingFrame] or [CloseFrame]
@@ -281,12 +388,45 @@ func (c *Conn) checkRSV1(h *Headers) bool {
 // It returns the Message Type, payload of the message and an err if the peer disconnects unexpectedly
 // or if it receives a [CloseFrame]
 func (c *Conn) NextMessage() (Opcode, []byte, error) {
+	return c.nextMessage(nil)
+}
+
+// NextMessageWithInfo is same as [Conn.NextMessage] except it returns the message
+// with metadata about how it was received, like its wire size and number of fragments.
+func (c *Conn) NextMessageWithInfo() (Message, error) {
+	var m Message
+	mt, payload, err := c.nextMessage(&m)
+	if err != nil {
+		return Message{}, err
+	}
+	m.Type = mt
+	m.Payload = payload
+	return m, nil
+}
+
+// nextMessage reads the next message, info is filled with the message metadata if it's not nil.
+func (c *Conn) nextMessage(info *Message) (Opcode, []byte, error) {
+	// payloads are read before they're inflated
+	var firstByteAt, readAt time.Time
+	if info != nil {
+		c.readAt = &readAt
+		defer func() { c.readAt = nil }()
+	}
+
 	// loop and ignore control message (eg. PING PONG)
 	for {
 		initialHeaders, err := c.parseFrameHeaders()
+		if info != nil && err == nil {
+			firstByteAt = time.Now()
+		}
 		if isEOF(err) {
+			c.closeConnCause(ErrUnexpectedClose)
 			return CloseFrame, nil, ErrUnexpectedClose
 		}
+		if err != nil {
+			c.closeConnCause(err)
+			return CloseFrame, nil, err
+		}
 
 		// Check reserved bits
 		if initialHeaders.RSV1 && c.checkRSV1(initialHeaders) ||
@@ -299,6 +439,23 @@ func (c *Conn) NextMessage() (Opcode, []byte, error) {
 			return c.closeWithErr(CloseProtocolError)
 		}
 
+		// inbound rate limits
+		dropped := false
+		if c.exceedsLimit(initialHeaders, true) {
+			if c.limiter.action == LimitClose {
+				return c.closeWithErr(ClosePolicyViolation)
+			}
+			if isPingPongFrame(initialHeaders.Opcode) {
+				// read it without answering
+				_, err = c.handlePongFrame(initialHeaders)
+				if err != nil {
+					return c.handleSingleFrameErr(err)
+				}
+				continue
+			}
+			dropped = true
+		}
+
 		// initial message payload
 		initialPayload, err := c.handleSingleFrame(initialHeaders)
 		if err != nil {
@@ -313,8 +470,21 @@ func (c *Conn) NextMessage() (Opcode, []byte, error) {
 		if initialHeaders.Opcode == ContinuationFrame {
 			return c.closeWithErr(CloseProtocolError)
 		}
+
+		if info != nil {
+			*info = Message{
+				Compressed:  initialHeaders.RSV1,
+				Fragments:   1,
+				WireSize:    initialHeaders.wireSize(),
+				FirstByteAt: firstByteAt,
+				LastByteAt:  readAt,
+			}
+		}
 		// Single frame
 		if initialHeaders.FIN {
+			if dropped {
+				continue
+			}
 			return initialHeaders.Opcode, initialPayload, nil
 		}
 
@@ -322,8 +492,13 @@ func (c *Conn) NextMessage() (Opcode, []byte, error) {
 		for {
 			nextHeaders, err := c.parseFrameHeaders()
 			if isEOF(err) {
+				c.closeConnCause(ErrUnexpectedClose)
 				return CloseFrame, nil, ErrUnexpectedClose
 			}
+			if err != nil {
+				c.closeConnCause(err)
+				return CloseFrame, nil, err
+			}
 
 			// illegal ContinuationFrame
 			if nextHeaders.Opcode != ContinuationFrame && !isControlFrame(nextHeaders.Opcode) {
@@ -336,6 +511,20 @@ func (c *Conn) NextMessage() (Opcode, []byte, error) {
 				return c.closeWithErr(CloseProtocolError)
 			}
 
+			if c.exceedsLimit(nextHeaders, false) {
+				if c.limiter.action == LimitClose {
+					return c.closeWithErr(ClosePolicyViolation)
+				}
+				if isPingPongFrame(nextHeaders.Opcode) {
+					_, err = c.handlePongFrame(nextHeaders)
+					if err != nil {
+						return c.handleSingleFrameErr(err)
+					}
+					continue
+				}
+				dropped = true
+			}
+
 			nextPayload, err := c.handleSingleFrame(nextHeaders)
 			if err != nil {
 				return c.handleSingleFrameErr(err)
@@ -349,6 +538,12 @@ func (c *Conn) NextMessage() (Opcode, []byte, error) {
 			// append data
 			initialPayload = append(initialPayload, nextPayload...)
 
+			if info != nil {
+				info.Fragments++
+				info.WireSize += nextHeaders.wireSize()
+				info.LastByteAt = readAt
+			}
+
 			if nextHeaders.FIN {
 				break
 			}
@@ -366,6 +561,10 @@ func (c *Conn) NextMessage() (Opcode, []byte, error) {
 			return c.closeWithErr(CloseMistachedPayloadData)
 		}
 
+		// the message is still read and inflated to keep the compression context
+		if dropped {
+			continue
+		}
 		return initialHeaders.Opcode, initialPayload, nil
 	}
 }
@@ -395,6 +594,14 @@ func (c *Conn) SendMessage(payload []byte, mt Opcode) (int, error) {
 		return 0, ErrInvalidMessageType
 	}
 
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	// no data frames after the close frame
+	if c.closeSent {
+		return 0, ErrCloseSent
+	}
+
 	shouldCompress := false
 	if c.cc.Enabled && len(payload) > c.cc.CompressionThreshold {
 		shouldCompress = true
@@ -424,7 +631,7 @@ func (c *Conn) SendMessage(payload []byte, mt Opcode) (int, error) {
 
 	buf = append(buf, payload...)
 
-	n, err := c.netConn.Write(buf)
+	n, err := c.writeFrame(buf)
 	if err != nil {
 		return n, err
 	}
@@ -480,26 +687,159 @@ func (c *Conn) sendControl(mt Opcode, status uint16, reason []byte) (int, error)
 	buf = append(buf, payload...)
 
 	// write control
-	n, err := c.netConn.Write(buf)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	// only one close frame is sent, replying to the peer's close is skipped if we started the handshake
+	if mt == CloseFrame {
+		if c.closeSent {
+			return 0, nil
+		}
+		c.closeSent = true
+	}
+	n, err := c.writeFrame(buf)
+	if err != nil {
+		return n, err
+	}
+	// control frames are never delayed
+	err = c.flush()
 
 	return n, err
 }
 
+// enableWriteBuffer makes frame writes go through a buffer of the given size,
+// the buffer is flushed on [Conn.Flush], when it's full or after interval if it's set.
+func (c *Conn) enableWriteBuffer(size int, interval time.Duration) {
+	if size <= 0 {
+		return
+	}
+	c.bw = bufio.NewWriterSize(c.netConn, size)
+	c.flushInterval = interval
+}
+
+// writeFrame writes a full frame to the peer, c.wmu must be held.
+func (c *Conn) writeFrame(buf []byte) (int, error) {
+	if c.bw == nil {
+		return c.netConn.Write(buf)
+	}
+
+	n, err := c.bw.Write(buf)
+	if err != nil {
+		return n, err
+	}
+	// arm auto flush for the first buffered frame
+	if c.flushInterval > 0 && c.flushTimer == nil && c.bw.Buffered() > 0 {
+		c.flushTimer = time.AfterFunc(c.flushInterval, func() {
+			_ = c.Flush()
+		})
+	}
+	return n, nil
+}
+
+// flush writes any buffered frames to the peer, c.wmu must be held.
+func (c *Conn) flush() error {
+	if c.bw == nil {
+		return nil
+	}
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+		c.flushTimer = nil
+	}
+	return c.bw.Flush()
+}
+
+// Flush writes any frames buffered by the write buffer to the peer,
+// it's a no-op if the connection has no WriteBufferSize configured.
+func (c *Conn) Flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.flush()
+}
+
+// startClose starts the close handshake by sending a close frame with code,
+// the connection is closed once the peer replies or if sending fails.
+func (c *Conn) startClose(code uint16) error {
+	_, err := c.sendControl(CloseFrame, code, nil)
+	if err != nil {
+		c.closeConn()
+	}
+	return err
+}
+
+// closeConn closes the underlying connection and stops the send queue,
+// it returns false if the connection was already closed.
+func (c *Conn) closeConn() bool {
+	return c.closeConnCause(nil)
+}
+
+// closeConnCause is closeConn with the cause of [Conn.Context], if cause is nil
+// it's the close frame received from the peer or [net.ErrClosed] if there's none.
+func (c *Conn) closeConnCause(cause error) bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	c.closed = true
+	if cause == nil {
+		if c.closeErr != nil {
+			cause = c.closeErr
+		} else {
+			cause = net.ErrClosed
+		}
+	}
+	sq := c.sq
+	onClose := c.onClose
+	c.onClose = nil
+	c.mu.Unlock()
+
+	if sq != nil {
+		sq.close(ErrSendQueueClosed)
+	}
+	c.netConn.Close()
+	c.cancel(cause)
+	for _, fn := range onClose {
+		fn()
+	}
+	return true
+}
+
+// addOnClose registers fn to be called once the connection is closed,
+// fn is called right away if it's already closed.
+func (c *Conn) addOnClose(fn func()) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		fn()
+		return
+	}
+	c.onClose = append(c.onClose, fn)
+	c.mu.Unlock()
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
 func (c *Conn) closeWithErr(code uint16) (Opcode, []byte, error) {
 	var err error
 	_, err = c.sendControl(CloseFrame, code, nil)
 	if isEOF(err) {
+		c.closeConnCause(ErrUnexpectedClose)
 		return CloseFrame, nil, ErrUnexpectedClose
 	}
 
-	if code == CloseMistachedPayloadData {
+	switch code {
+	case CloseMistachedPayloadData:
 		err = ErrUtf8
-	} else {
+	case ClosePolicyViolation:
+		err = ErrPolicyViolation
+	default:
 		err = ErrBadMessage
 	}
 
-	c.closed = true
-	c.netConn.Close()
+	c.closeConnCause(err)
 	return CloseFrame, nil, err
 }
 
@@ -507,16 +847,57 @@ func (c *Conn) Subprotocol() string {
 	return c.subprotocol
 }
 
+// Extensions returns the negotiated extensions with their parameters,
+// the returned value MUST NOT be modified.
+func (c *Conn) Extensions() []Extension {
+	return c.extensions
+}
+
+// Request returns the handshake request, on the server it's the upgraded request
+// and on the client it's the request sent to the server.
+// It's nil for connections received with ReceiveConn, the returned value MUST NOT be modified.
+func (c *Conn) Request() *http.Request {
+	return c.request
+}
+
+// Context returns the connection's context, it's derived from the upgraded request on the server
+// and the dial context on the client without their cancellation or deadline.
+//
+// It's cancelled once the connection is closed by either side, [context.Cause] returns
+// the [*CloseError] received from the peer, the read error that closed it or [net.ErrClosed].
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.netConn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.netConn.RemoteAddr()
+}
+
+// TLSConnectionState returns the TLS state of the connection, ok is false if it doesn't use TLS.
+func (c *Conn) TLSConnectionState() (state tls.ConnectionState, ok bool) {
+	tlsConn, ok := c.netConn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tlsConn.ConnectionState(), true
+}
+
 // Close writes the websocket close frame,
 // and closes the underlying connections.
 func (c *Conn) Close() {
-	if !c.closed {
+	if !c.isClosed() {
 		if c.isServer {
 			c.sendControl(CloseFrame, CloseGoingAway, nil)
 		} else {
 			c.sendControl(CloseFrame, CloseNormal, nil)
 		}
-		c.netConn.Close()
+		c.closeConn()
 	}
 
 	if c.flatter != nil {
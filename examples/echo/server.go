@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -21,14 +22,8 @@ var upgrader = &websocket.Upgrader{
 }
 
 func main() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		ws, err := upgrader.Upgrade(w, r)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		defer ws.Close()
-
+	var mux websocket.ServeMux
+	mux.Handle("/", upgrader, func(ctx context.Context, ws *websocket.Conn) {
 		for {
 			mt, payload, err := ws.NextMessage()
 			if err != nil {
@@ -41,9 +36,8 @@ func main() {
 				fmt.Println(err)
 				return
 			}
-
 		}
 	})
 
-	http.ListenAndServe(":8080", http.DefaultServeMux)
+	http.ListenAndServe(":8080", &mux)
 }
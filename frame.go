@@ -78,7 +78,10 @@ const (
 	CloseFrameTooBig
 	CloseRequiredExtension
 	CloseInternalServerErr
-	CloseFailedTLS uint16 = 1015
+	CloseServiceRestart
+	CloseTryAgainLater
+	CloseBadGateway
+	CloseFailedTLS
 )
 
 const (
@@ -100,6 +103,9 @@ var validCloseFrameCodes = map[uint16]bool{
 	CloseFrameTooBig:          true,
 	CloseRequiredExtension:    true,
 	CloseInternalServerErr:    true,
+	CloseServiceRestart:       true,
+	CloseTryAgainLater:        true,
+	CloseBadGateway:           true,
 	CloseFailedTLS:            false,
 }
 
@@ -200,6 +206,21 @@ func (c *Conn) parseFrameHeaders() (*Headers, error) {
 	}, nil
 }
 
+// wireSize returns the size of the frame on the wire, headers and payload.
+func (h *Headers) wireSize() int {
+	size := 2
+	switch {
+	case h.PayloadLength > 65535:
+		size += 8
+	case h.PayloadLength > 125:
+		size += 2
+	}
+	if h.Mask {
+		size += 4
+	}
+	return size + int(h.PayloadLength)
+}
+
 func makeFrameHeadersBuf(h *Headers) []byte {
 	buf := make([]byte, 0)
 
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime"
+)
+
+// HandlerFunc handles an upgraded connection,
+// ctx is the connection's [Conn.Context] so it's cancelled once the connection is closed.
+type HandlerFunc func(ctx context.Context, c *Conn)
+
+// Handler returns an [http.Handler] that upgrades the request with u and calls fn,
+// if u is nil the default [Upgrader] is used.
+//
+// The connection is closed when fn returns, if fn panics the panic is logged
+// and the connection is closed with [CloseInternalServerErr].
+func Handler(u *Upgrader, fn HandlerFunc) http.Handler {
+	if u == nil {
+		u = &Upgrader{}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response was already written on failure
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v != http.ErrAbortHandler {
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				log.Printf("websocket: panic serving %s: %v\n%s", r.RemoteAddr, v, buf)
+			}
+			c.sendControl(CloseFrame, CloseInternalServerErr, nil)
+			c.closeConn()
+		}()
+
+		fn(c.Context(), c)
+	})
+}
+
+// Middleware wraps the handler of a route, like authentication or logging.
+type Middleware func(next http.Handler) http.Handler
+
+// ServeMux routes websocket handshakes to handlers, patterns are the same as [http.ServeMux].
+//
+// Every route has its own [Upgrader] and middleware, the zero value is ready to use.
+type ServeMux struct {
+	// Upgrader is used by the routes registered without their own,
+	// if not assigned the default [Upgrader] is used.
+	Upgrader *Upgrader
+
+	mux        http.ServeMux
+	middleware []Middleware
+}
+
+// Use appends middleware that wraps every route registered after it,
+// the middleware wraps the route's own middleware.
+func (m *ServeMux) Use(middleware ...Middleware) {
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// Handle registers fn for pattern, the request is upgraded with u or [ServeMux.Upgrader] if u is nil.
+// The first middleware is the outermost, it panics if pattern is invalid or conflicts with another route.
+func (m *ServeMux) Handle(pattern string, u *Upgrader, fn HandlerFunc, middleware ...Middleware) {
+	if u == nil {
+		u = m.Upgrader
+	}
+
+	h := Handler(u, fn)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		h = m.middleware[i](h)
+	}
+	m.mux.Handle(pattern, h)
+}
+
+// ServeHTTP dispatches the request to the route that matches it.
+func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
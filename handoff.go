@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrHandoffUnsupported = errors.New("websocket: connection handoff is not supported")
+	ErrHandoffState       = errors.New("websocket: invalid handoff state")
+)
+
+// maxHandoffState is the maximum size of the serialized state,
+// the sliding window and the read buffer are the only big fields.
+const maxHandoffState = 4 << 20
+
+// handoffState is the state of a [Conn] passed to another process with [Conn.Handoff].
+type handoffState struct {
+	Subprotocol string            `json:"subprotocol"`
+	IsServer    bool              `json:"is_server"`
+	Compression CompressionConfig `json:"compression"`
+	Extensions  []Extension       `json:"extensions,omitempty"`
+	CloseSent   bool              `json:"close_sent"`
+
+	// Window is the inflate sliding window, the deflater is reset
+	// for every message so it has no state to pass.
+	Window []byte `json:"window,omitempty"`
+
+	// Buffered is the bytes that were read from the socket but not parsed yet.
+	Buffered []byte `json:"buffered,omitempty"`
+}
+
+// handoffState captures the connection state, c.wmu MUST be held.
+func (c *Conn) handoffState() handoffState {
+	state := handoffState{
+		Subprotocol: c.subprotocol,
+		IsServer:    c.isServer,
+		Compression: *c.cc,
+		Extensions:  c.extensions,
+		CloseSent:   c.closeSent,
+	}
+	if c.flatter != nil && c.flatter.isContextTakeover {
+		state.Window = bytes.Clone(c.flatter.sw.buf)
+	}
+	buffered, _ := c.br.Peek(c.br.Buffered())
+	state.Buffered = bytes.Clone(buffered)
+	return state
+}
+
+func (state *handoffState) validate(isServer bool) error {
+	if state.IsServer != isServer {
+		return ErrHandoffState
+	}
+	if state.Window != nil && (!state.Compression.Enabled || !state.Compression.IsContextTakeover) {
+		return ErrHandoffState
+	}
+	// same size as getSlidingWindow
+	if len(state.Window) > 32*1024 {
+		return ErrHandoffState
+	}
+	return nil
+}
+
+// restoreHandoff restores the state that isn't set by newConn.
+func (c *Conn) restoreHandoff(state handoffState) {
+	c.closeSent = state.CloseSent
+	if c.flatter != nil && c.flatter.isContextTakeover {
+		c.flatter.sw.buf = append(c.flatter.sw.buf[:0], state.Window...)
+	}
+}
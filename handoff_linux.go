@@ -0,0 +1,182 @@
+//go:build linux
+
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Handoff passes the connection to another process over the unix socket uc,
+// the other process rebuilds it with [Upgrader.ReceiveConn] or [Dialer.ReceiveConn].
+//
+// The socket is sent with SCM_RIGHTS along with the negotiated state, the inflate window
+// and any unread bytes, so the peer doesn't notice the handoff.
+// Stop reading from and writing to c before calling Handoff, and remove it from any [Poller].
+// On success c is closed without a close frame, TLS connections are not supported.
+func (c *Conn) Handoff(uc *net.UnixConn) error {
+	sc, ok := c.netConn.(syscall.Conn)
+	if !ok {
+		return ErrHandoffUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// hold the write lock so no frame is written while the state is captured
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.isClosed() {
+		return net.ErrClosed
+	}
+	err = c.flush()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c.handoffState())
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	msg = append(msg, data...)
+
+	var n int
+	cerr := rc.Control(func(fd uintptr) {
+		n, _, err = uc.WriteMsgUnix(msg, syscall.UnixRights(int(fd)), nil)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return err
+	}
+	// the rights are attached to the first byte, the rest can follow
+	if n < len(msg) {
+		_, err = uc.Write(msg[n:])
+		if err != nil {
+			return err
+		}
+	}
+
+	// the other process has its own copy of the socket
+	c.closeConn()
+	return nil
+}
+
+// ReceiveConn receives a server connection passed with [Conn.Handoff],
+// the connection is configured by u same as if it was upgraded by u.
+func (u *Upgrader) ReceiveConn(uc *net.UnixConn) (*Conn, error) {
+	netConn, state, err := receiveHandoff(uc, true)
+	if err != nil {
+		return nil, err
+	}
+
+	hs := &serverHandshake{
+		subprotocol: state.Subprotocol,
+		cc:          &state.Compression,
+		extensions:  state.Extensions,
+		release:     func() {},
+	}
+	conn := u.newServerConn(netConn, state.Buffered, hs, nil)
+	conn.restoreHandoff(state)
+	return conn, nil
+}
+
+// ReceiveConn receives a client connection passed with [Conn.Handoff],
+// the connection is configured by d same as if it was dialed by d.
+func (d *Dialer) ReceiveConn(uc *net.UnixConn) (*Conn, error) {
+	netConn, state, err := receiveHandoff(uc, false)
+	if err != nil {
+		return nil, err
+	}
+
+	br := newBufioReader(state.Buffered, netConn, d.ReadBufferSize)
+	conn := d.newClientConn(context.Background(), netConn, br, &state.Compression, state.Subprotocol, state.Extensions, nil)
+	conn.restoreHandoff(state)
+	return conn, nil
+}
+
+// receiveHandoff reads the socket and state sent by [Conn.Handoff].
+func receiveHandoff(uc *net.UnixConn, isServer bool) (net.Conn, handoffState, error) {
+	var state handoffState
+
+	// read only the length prefix so nothing past this handoff is consumed
+	buf := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, state, err
+	}
+
+	netConn, err := handoffConn(oob[:oobn])
+	if err != nil {
+		return nil, state, err
+	}
+
+	err = readHandoffState(uc, buf[:n], &state)
+	if err == nil {
+		err = state.validate(isServer)
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, state, err
+	}
+	return netConn, state, nil
+}
+
+// handoffConn makes a [net.Conn] from the socket in the control message.
+func handoffConn(oob []byte) (net.Conn, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, fmt.Errorf("%w: expected a single socket, got %d", ErrHandoffState, len(fds))
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "websocket")
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// readHandoffState decodes the length prefixed state, head is the part of the prefix read with the socket.
+func readHandoffState(r io.Reader, head []byte, state *handoffState) error {
+	if len(head) < 4 {
+		rest := make([]byte, 4-len(head))
+		_, err := io.ReadFull(r, rest)
+		if err != nil {
+			return err
+		}
+		head = append(head, rest...)
+	}
+
+	size := binary.BigEndian.Uint32(head)
+	if size > maxHandoffState {
+		return ErrHandoffState
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, state)
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// unixPair returns both ends of a connected unix socket pair.
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+		t.Cleanup(func() { c.Close() })
+	}
+	return conns[0], conns[1]
+}
+
+func TestHandoff(t *testing.T) {
+	cc := CompressionConfig{Enabled: true, IsContextTakeover: true, CompressionThreshold: 1}
+	u := &Upgrader{CompressionConfig: cc, Subprotocols: []string{"chat"}}
+	d := &Dialer{CompressionConfig: cc, Subprotocols: []string{"chat"}, WriteBufferSize: 4096}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		netConn, err := ln.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		c, _, err := u.UpgradeConn(netConn, nil)
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- c
+	}()
+
+	client, _, err := d.Dial("ws://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	if server == nil {
+		t.Fatal("upgrade failed")
+	}
+
+	// the second message references the first one's window,
+	// both are written at once so the second is buffered by the server.
+	msg := strings.Repeat("hello handoff ", 8)
+	for _, p := range []string{"1 " + msg, "2 " + msg} {
+		if _, err := client.SendMessage([]byte(p), TextMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, payload, err := server.NextMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "1 "+msg {
+		t.Fatalf("payload = %q, want %q", payload, "1 "+msg)
+	}
+	if server.br.Buffered() == 0 {
+		t.Fatal("expected the second message to be buffered before the handoff")
+	}
+
+	// server handoff
+	a, b := unixPair(t)
+	if err := server.Handoff(a); err != nil {
+		t.Fatal(err)
+	}
+	received, err := u.ReceiveConn(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer received.Close()
+
+	if received.Subprotocol() != "chat" {
+		t.Fatalf("Subprotocol() = %q, want %q", received.Subprotocol(), "chat")
+	}
+	if len(received.Extensions()) != 1 || received.Extensions()[0].Name != "permessage-deflate" {
+		t.Fatalf("Extensions() = %v, want permessage-deflate", received.Extensions())
+	}
+
+	_, payload, err = received.NextMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "2 "+msg {
+		t.Fatalf("buffered payload = %q, want %q", payload, "2 "+msg)
+	}
+
+	// a new message still uses the window from before the handoff
+	if _, err := client.SendMessage([]byte("3 "+msg), TextMessage); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err = received.NextMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "3 "+msg {
+		t.Fatalf("payload = %q, want %q", payload, "3 "+msg)
+	}
+
+	// client handoff
+	a, b = unixPair(t)
+	if err := client.Handoff(a); err != nil {
+		t.Fatal(err)
+	}
+	receivedClient, err := d.ReceiveConn(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receivedClient.Close()
+
+	if _, err := received.SendMessage([]byte("4 "+msg), TextMessage); err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err = receivedClient.NextMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "4 "+msg {
+		t.Fatalf("payload = %q, want %q", payload, "4 "+msg)
+	}
+}
+
+func TestHandoffInvalidState(t *testing.T) {
+	a, b := unixPair(t)
+	sock, _ := unixPair(t)
+	f, err := sock.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// a client connection can't be received as a server connection
+	state := []byte(`{"is_server":false}`)
+	msg := append([]byte{0, 0, 0, byte(len(state))}, state...)
+	if _, _, err := a.WriteMsgUnix(msg, syscall.UnixRights(int(f.Fd())), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = (&Upgrader{}).ReceiveConn(b)
+	if !errors.Is(err, ErrHandoffState) {
+		t.Fatalf("err = %v, want %v", err, ErrHandoffState)
+	}
+}
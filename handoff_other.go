@@ -0,0 +1,20 @@
+//go:build !linux
+
+package websocket
+
+import "net"
+
+// Handoff is only supported on linux, on other platforms it returns [ErrHandoffUnsupported].
+func (c *Conn) Handoff(uc *net.UnixConn) error {
+	return ErrHandoffUnsupported
+}
+
+// ReceiveConn always returns [ErrHandoffUnsupported] on this platform.
+func (u *Upgrader) ReceiveConn(uc *net.UnixConn) (*Conn, error) {
+	return nil, ErrHandoffUnsupported
+}
+
+// ReceiveConn always returns [ErrHandoffUnsupported] on this platform.
+func (d *Dialer) ReceiveConn(uc *net.UnixConn) (*Conn, error) {
+	return nil, ErrHandoffUnsupported
+}
@@ -0,0 +1,266 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ErrMalformedHeader = errors.New("websocket: malformed header value")
+
+// Parsing of the handshake header values as defined by RFC 7230 section 3.2.6 and 7,
+// used for the Sec-WebSocket-Protocol and Sec-WebSocket-Extensions headers.
+
+// isTokenChar reports whether c is a tchar.
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// skipSpace skips any optional whitespace (OWS).
+func skipSpace(s string) string {
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return s[i:]
+}
+
+// nextToken returns the token at the start of s and the rest of s.
+func nextToken(s string) (string, string) {
+	i := 0
+	for i < len(s) && isTokenChar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// nextQuoted returns the unescaped quoted-string at the start of s and the rest of s,
+// ok is false if s doesn't start with a valid quoted-string.
+func nextQuoted(s string) (value string, rest string, ok bool) {
+	if len(s) == 0 || s[0] != '"' {
+		return "", s, false
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return b.String(), s[i+1:], true
+		case c == '\\':
+			// quoted-pair
+			i++
+			if i == len(s) {
+				return "", s, false
+			}
+			b.WriteByte(s[i])
+		case c == '\t' || c == ' ' || c >= 0x21 && c != 0x7f:
+			b.WriteByte(c)
+		default:
+			return "", s, false
+		}
+	}
+	// missing closing quote
+	return "", s, false
+}
+
+// parseTokenList parses the comma separated list of tokens in values (1#token),
+// empty list elements are ignored.
+func parseTokenList(values []string) ([]string, error) {
+	var tokens []string
+	for _, s := range values {
+		for {
+			s = skipSpace(s)
+			if s == "" {
+				break
+			}
+			if s[0] == ',' {
+				s = s[1:]
+				continue
+			}
+
+			var t string
+			t, s = nextToken(s)
+			if t == "" {
+				return tokens, ErrMalformedHeader
+			}
+			tokens = append(tokens, t)
+
+			s = skipSpace(s)
+			if s != "" && s[0] != ',' {
+				return tokens, ErrMalformedHeader
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// Extension is a negotiated websocket extension and its parameters,
+// parameters without a value map to an empty string.
+type Extension struct {
+	Name   string
+	Params map[string]string
+}
+
+// toExtensions converts the parsed extensions to [Extension].
+func toExtensions(exts []extension) []Extension {
+	if len(exts) == 0 {
+		return nil
+	}
+	out := make([]Extension, 0, len(exts))
+	for _, ext := range exts {
+		params := make(map[string]string, len(ext.params))
+		for _, p := range ext.params {
+			params[p.name] = p.value
+		}
+		out = append(out, Extension{Name: ext.name, Params: params})
+	}
+	return out
+}
+
+type extensionParam struct {
+	name string
+	// value is empty if the parameter has no value
+	value string
+}
+
+type extension struct {
+	name   string
+	params []extensionParam
+}
+
+// parseExtHeader parses all the Sec-WebSocket-Extensions header lines as defined in RFC 6455 section 9.1:
+//
+//	extension-list = 1#extension
+//	extension = extension-token *( ";" extension-param )
+//	extension-param = token [ "=" (token | quoted-string) ]
+//
+// The parsed extensions before any malformed value are returned with [ErrMalformedHeader].
+func parseExtHeader(h http.Header) ([]extension, error) {
+	exts := make([]extension, 0)
+
+	for _, s := range h.Values("Sec-WebSocket-Extensions") {
+		for {
+			s = skipSpace(s)
+			if s == "" {
+				break
+			}
+			if s[0] == ',' {
+				s = s[1:]
+				continue
+			}
+
+			var ext extension
+			ext.name, s = nextToken(s)
+			if ext.name == "" {
+				return exts, ErrMalformedHeader
+			}
+
+			// params
+			for {
+				s = skipSpace(s)
+				if s == "" || s[0] != ';' {
+					break
+				}
+				s = skipSpace(s[1:])
+
+				var p extensionParam
+				p.name, s = nextToken(s)
+				if p.name == "" {
+					return exts, ErrMalformedHeader
+				}
+
+				s = skipSpace(s)
+				if s != "" && s[0] == '=' {
+					s = skipSpace(s[1:])
+					if s != "" && s[0] == '"' {
+						var ok bool
+						p.value, s, ok = nextQuoted(s)
+						// quoted values MUST be valid tokens after unescaping
+						if !ok || !isToken(p.value) {
+							return exts, ErrMalformedHeader
+						}
+					} else {
+						p.value, s = nextToken(s)
+						if p.value == "" {
+							return exts, ErrMalformedHeader
+						}
+					}
+				}
+
+				ext.params = append(ext.params, p)
+			}
+
+			if s != "" && s[0] != ',' {
+				return exts, ErrMalformedHeader
+			}
+			exts = append(exts, ext)
+		}
+	}
+
+	return exts, nil
+}
+
+// parseFlateParams validates the permessage-deflate parameters as defined in RFC 7692 section 7,
+// isResponse is true for the parameters the server responded with.
+// It returns false if the offer/response must be declined.
+func parseFlateParams(params []extensionParam, isResponse bool) (isServerNoTakeover, isClientNoTakeover, ok bool) {
+	seen := make(map[string]bool, len(params))
+	for _, p := range params {
+		// every parameter MUST NOT appear more than once
+		if seen[p.name] {
+			return false, false, false
+		}
+		seen[p.name] = true
+
+		switch p.name {
+		case "client_no_context_takeover":
+			if p.value != "" {
+				return false, false, false
+			}
+			isServerNoTakeover = true
+		case "server_no_context_takeover":
+			if p.value != "" {
+				return false, false, false
+			}
+			isClientNoTakeover = true
+		case "server_max_window_bits":
+			// we can't adjust the deflate window, but any window fits when inflating
+			if isResponse && !isValidWindowBits(p.value) || !isResponse && p.value != "15" {
+				return false, false, false
+			}
+		case "client_max_window_bits":
+			if isResponse && p.value != "15" || !isResponse && p.value != "" && !isValidWindowBits(p.value) {
+				return false, false, false
+			}
+		default:
+			return false, false, false
+		}
+	}
+
+	return isServerNoTakeover, isClientNoTakeover, true
+}
+
+// isValidWindowBits checks for a decimal integer in the range 8 to 15 without leading zeros.
+func isValidWindowBits(v string) bool {
+	switch v {
+	case "8", "9", "10", "11", "12", "13", "14", "15":
+		return true
+	}
+	return false
+}
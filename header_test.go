@@ -0,0 +1,203 @@
+package websocket
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseTokenList(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		// RFC 6455 section 1.9
+		{"single", []string{"chat"}, []string{"chat"}, false},
+		{"list", []string{"chat, superchat"}, []string{"chat", "superchat"}, false},
+		{"multiple lines", []string{"chat", "superchat, v2.chat"}, []string{"chat", "superchat", "v2.chat"}, false},
+		{"tabs and spaces", []string{" \tchat\t ,  superchat "}, []string{"chat", "superchat"}, false},
+		// RFC 7230 section 7, empty elements are ignored
+		{"empty elements", []string{",chat,, ,superchat,"}, []string{"chat", "superchat"}, false},
+		{"empty value", []string{""}, nil, false},
+		{"missing comma", []string{"chat superchat"}, []string{"chat"}, true},
+		{"separator in token", []string{"chat/1"}, []string{"chat"}, true},
+		{"quoted", []string{`"chat"`}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTokenList(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExtHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []extension
+		wantErr bool
+	}{
+		{
+			name:   "no params",
+			values: []string{"permessage-deflate"},
+			want:   []extension{{name: "permessage-deflate"}},
+		},
+		{
+			// RFC 7692 section 7.1.2.2
+			name:   "quoted value",
+			values: []string{`permessage-deflate; client_max_window_bits="10"`},
+			want: []extension{{name: "permessage-deflate", params: []extensionParam{
+				{name: "client_max_window_bits", value: "10"},
+			}}},
+		},
+		{
+			// RFC 7692 section 5.1
+			name: "multiple offers",
+			values: []string{
+				"permessage-deflate; client_max_window_bits; server_max_window_bits=10, " +
+					"permessage-deflate; client_max_window_bits",
+			},
+			want: []extension{
+				{name: "permessage-deflate", params: []extensionParam{
+					{name: "client_max_window_bits"},
+					{name: "server_max_window_bits", value: "10"},
+				}},
+				{name: "permessage-deflate", params: []extensionParam{
+					{name: "client_max_window_bits"},
+				}},
+			},
+		},
+		{
+			// RFC 6455 section 9.1
+			name:   "multiple header lines",
+			values: []string{"foo", "bar; baz=2"},
+			want: []extension{
+				{name: "foo"},
+				{name: "bar", params: []extensionParam{{name: "baz", value: "2"}}},
+			},
+		},
+		{
+			name:   "spaces around separators",
+			values: []string{"foo ;  a = 1 ;b, bar"},
+			want: []extension{
+				{name: "foo", params: []extensionParam{{name: "a", value: "1"}, {name: "b"}}},
+				{name: "bar"},
+			},
+		},
+		{
+			// parsing keeps duplicates, parseFlateParams rejects them
+			name:   "duplicate params",
+			values: []string{"permessage-deflate; server_no_context_takeover; server_no_context_takeover"},
+			want: []extension{{name: "permessage-deflate", params: []extensionParam{
+				{name: "server_no_context_takeover"},
+				{name: "server_no_context_takeover"},
+			}}},
+		},
+		{
+			name:   "empty elements",
+			values: []string{", foo,,"},
+			want:   []extension{{name: "foo"}},
+		},
+		{
+			name:    "missing param name",
+			values:  []string{"foo; =1"},
+			want:    []extension{},
+			wantErr: true,
+		},
+		{
+			name:    "missing param value",
+			values:  []string{"foo; a="},
+			want:    []extension{},
+			wantErr: true,
+		},
+		{
+			name:    "quoted value isn't a token",
+			values:  []string{`foo; a="1 2"`},
+			want:    []extension{},
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quoted value",
+			values:  []string{`foo; a="1`},
+			want:    []extension{},
+			wantErr: true,
+		},
+		{
+			name:    "missing comma",
+			values:  []string{"foo bar"},
+			want:    []extension{},
+			wantErr: true,
+		},
+		{
+			name:    "malformed after valid extension",
+			values:  []string{"foo, ;bar"},
+			want:    []extension{{name: "foo"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{"Sec-Websocket-Extensions": tt.values}
+			got, err := parseExtHeader(h)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFlateParams(t *testing.T) {
+	tests := []struct {
+		name             string
+		params           []extensionParam
+		isResponse       bool
+		serverNoTakeover bool
+		clientNoTakeover bool
+		ok               bool
+	}{
+		{"no params", nil, false, false, false, true},
+		{"client_no_context_takeover", []extensionParam{{name: "client_no_context_takeover"}}, false, true, false, true},
+		{"server_no_context_takeover", []extensionParam{{name: "server_no_context_takeover"}}, false, false, true, true},
+		{"client_max_window_bits without value", []extensionParam{{name: "client_max_window_bits"}}, false, false, false, true},
+		{"client_max_window_bits", []extensionParam{{name: "client_max_window_bits", value: "10"}}, false, false, false, true},
+		{"server_max_window_bits 15", []extensionParam{{name: "server_max_window_bits", value: "15"}}, false, false, false, true},
+		{"server_max_window_bits smaller", []extensionParam{{name: "server_max_window_bits", value: "10"}}, false, false, false, false},
+		{"server_max_window_bits without value", []extensionParam{{name: "server_max_window_bits"}}, false, false, false, false},
+		{"window bits out of range", []extensionParam{{name: "client_max_window_bits", value: "16"}}, false, false, false, false},
+		{"window bits leading zero", []extensionParam{{name: "client_max_window_bits", value: "08"}}, false, false, false, false},
+		{"takeover param with value", []extensionParam{{name: "server_no_context_takeover", value: "1"}}, false, false, false, false},
+		{"duplicate param", []extensionParam{{name: "client_no_context_takeover"}, {name: "client_no_context_takeover"}}, false, false, false, false},
+		{"unknown param", []extensionParam{{name: "foo"}}, false, false, false, false},
+
+		// RFC 7692 section 7.1.2.1, the server may use a smaller window than we can inflate
+		{"response server_max_window_bits smaller", []extensionParam{{name: "server_max_window_bits", value: "10"}}, true, false, false, true},
+		{"response server_max_window_bits without value", []extensionParam{{name: "server_max_window_bits"}}, true, false, false, false},
+		{"response client_max_window_bits 15", []extensionParam{{name: "client_max_window_bits", value: "15"}}, true, false, false, true},
+		{"response client_max_window_bits smaller", []extensionParam{{name: "client_max_window_bits", value: "10"}}, true, false, false, false},
+		{"response server_no_context_takeover", []extensionParam{{name: "server_no_context_takeover"}}, true, false, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverNoTakeover, clientNoTakeover, ok := parseFlateParams(tt.params, tt.isResponse)
+			if serverNoTakeover != tt.serverNoTakeover || clientNoTakeover != tt.clientNoTakeover || ok != tt.ok {
+				t.Fatalf("got (%v, %v, %v), want (%v, %v, %v)",
+					serverNoTakeover, clientNoTakeover, ok,
+					tt.serverNoTakeover, tt.clientNoTakeover, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net"
+	"time"
+)
+
+// inboxSize is the number of messages buffered by [Conn.Inbox].
+const inboxSize = 16
+
+// Message is a [TextMessage] or [BinaryMessage] message.
+//
+// The metadata fields are only set for received messages.
+type Message struct {
+	Type    Opcode
+	Payload []byte
+
+	// Compressed is whether the message was received compressed with permessage-deflate.
+	Compressed bool
+	// Fragments is the number of frames the message was received in, not counting control frames.
+	Fragments int
+	// WireSize is the size of the message frames on the wire including their headers.
+	WireSize int
+	// FirstByteAt and LastByteAt are when the first frame header and the last frame payload were read.
+	FirstByteAt time.Time
+	LastByteAt  time.Time
+}
+
+// Messages returns an iterator over the received messages, it calls [Conn.NextMessageWithInfo] until it fails.
+// The iteration stops without an error when the peer closes the connection with a close frame
+// or it's closed locally, any other error is yielded once as the last element.
+func (c *Conn) Messages() iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		for {
+			m, err := c.NextMessageWithInfo()
+			if err != nil {
+				if !c.isNormalClose(err) {
+					yield(Message{}, err)
+				}
+				return
+			}
+			if !yield(m, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Inbox returns a channel of the received messages, the first call starts a goroutine
+// that reads with [Conn.NextMessageWithInfo] so no one else should read from c.
+//
+// Up to 16 messages are buffered, reading stops while the buffer is full.
+// The channel is closed once reading fails, see [Conn.InboxErr] for the error.
+func (c *Conn) Inbox() <-chan Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inbox == nil {
+		c.inbox = make(chan Message, inboxSize)
+		go c.readInbox()
+	}
+	return c.inbox
+}
+
+func (c *Conn) readInbox() {
+	var err error
+loop:
+	for {
+		var m Message
+		m, err = c.NextMessageWithInfo()
+		if err != nil {
+			break
+		}
+		// don't block on a full inbox nobody reads after the connection is closed
+		select {
+		case c.inbox <- m:
+		case <-c.ctx.Done():
+			err = context.Cause(c.ctx)
+			break loop
+		}
+	}
+
+	normal := c.isNormalClose(err)
+	c.mu.Lock()
+	if !normal {
+		c.inboxErr = err
+	}
+	c.mu.Unlock()
+	close(c.inbox)
+}
+
+// InboxErr returns the error that closed the [Conn.Inbox] channel,
+// it's nil if the channel isn't closed yet or the connection was closed normally.
+func (c *Conn) InboxErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inboxErr
+}
+
+// Outbox returns a channel for sending messages, the first call starts a goroutine
+// that passes them to [Conn.Enqueue] so they're buffered by the send queue not the channel.
+//
+// Closing the channel waits for the queued messages to be written then closes the connection.
+// Once the connection is closed messages sent on the channel are dropped with [ErrSendQueueClosed],
+// the goroutine stops when the channel is closed.
+//
+// Messages that can't be queued or written are dropped, see [Conn.OutboxErr].
+func (c *Conn) Outbox() chan<- Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.outbox == nil {
+		c.outbox = make(chan Message)
+		done := make(chan struct{})
+		if c.closed {
+			close(done)
+		} else {
+			c.onClose = append(c.onClose, func() { close(done) })
+		}
+		go c.writeOutbox(c.outbox, done)
+	}
+	return c.outbox
+}
+
+func (c *Conn) writeOutbox(outbox <-chan Message, done <-chan struct{}) {
+	var last *SendFuture
+	for {
+		select {
+		case m, ok := <-outbox:
+			if !ok {
+				if last != nil {
+					last.Wait()
+				}
+				c.Close()
+				return
+			}
+			f, err := c.enqueue(m.Payload, m.Type, c.setOutboxErr)
+			if err != nil {
+				c.setOutboxErr(err)
+				continue
+			}
+			last = f
+		case <-done:
+			// keep receiving so senders don't block
+			for range outbox {
+				c.setOutboxErr(ErrSendQueueClosed)
+			}
+			return
+		}
+	}
+}
+
+// OutboxErr returns the error of the first message sent on [Conn.Outbox] that was dropped,
+// like an invalid message type, a full queue with [QueueDropNewest], a failed write or a closed connection.
+// Sending continues after a message is dropped.
+func (c *Conn) OutboxErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.outboxErr
+}
+
+func (c *Conn) setOutboxErr(err error) {
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.outboxErr == nil {
+		c.outboxErr = err
+	}
+	c.mu.Unlock()
+}
+
+// isNormalClose reports whether err is from the close handshake or from reading after a local close.
+func (c *Conn) isNormalClose(err error) bool {
+	return errors.Is(err, ErrNormalClose) || errors.Is(err, net.ErrClosed) && c.isClosed()
+}
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// dialPair dials a server that runs handler and returns the client connection.
+func dialPair(t *testing.T, handler func(c *Conn)) *Conn {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := newWsServer(t, mux, make(chan http.Header, 1))
+	mux.HandleFunc("/pair", func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		handler(c)
+	})
+
+	c, _, err := Dial(wsURL(srv, "/pair"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestInboxClosedWhileBlocked(t *testing.T) {
+	sent := make(chan struct{})
+	c := dialPair(t, func(c *Conn) {
+		c.SendMessage([]byte("hello"), TextMessage)
+		close(sent)
+		c.NextMessage()
+	})
+
+	// an unbuffered inbox nobody receives from blocks the goroutine on the first message
+	c.mu.Lock()
+	c.inbox = make(chan Message)
+	c.mu.Unlock()
+	exited := make(chan struct{})
+	go func() {
+		c.readInbox()
+		close(exited)
+	}()
+	<-sent
+
+	c.Close()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("inbox goroutine still blocked after the connection was closed")
+	}
+	if _, ok := <-c.inbox; ok {
+		t.Fatal("received a message after the connection was closed")
+	}
+	if err := c.InboxErr(); err != nil {
+		t.Fatalf("InboxErr() = %v, want nil", err)
+	}
+}
+
+func TestOutboxAfterClose(t *testing.T) {
+	c := dialPair(t, func(c *Conn) { c.NextMessage() })
+
+	outbox := c.Outbox()
+	c.Close()
+	for range 3 {
+		select {
+		case outbox <- Message{Type: TextMessage, Payload: []byte("hello")}:
+		case <-time.After(5 * time.Second):
+			t.Fatal("send on the outbox blocked after the connection was closed")
+		}
+	}
+	close(outbox)
+
+	if err := c.OutboxErr(); !errors.Is(err, ErrSendQueueClosed) {
+		t.Fatalf("OutboxErr() = %v, want %v", err, ErrSendQueueClosed)
+	}
+}
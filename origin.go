@@ -0,0 +1,281 @@
+package websocket
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrCSRFToken = errors.New("websocket: missing or mismatched CSRF token")
+
+// origin is a normalized serialized origin as defined in RFC 6454,
+// the port is always set and the host is lowercase ASCII.
+type origin struct {
+	scheme   string
+	host     string
+	port     string
+	wildcard bool
+}
+
+// defaultPort returns the default port of the scheme.
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+// parseOrigin parses and normalizes an Origin header value or an allowlist pattern,
+// the opaque origin "null" and origins with userinfo, path, query or fragment are rejected.
+func parseOrigin(s string, isPattern bool) (origin, bool) {
+	if s == "" || s == "null" {
+		return origin{}, false
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Opaque != "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return origin{}, false
+	}
+	if u.Path != "" && !(isPattern && u.Path == "/") {
+		return origin{}, false
+	}
+
+	o := origin{scheme: strings.ToLower(u.Scheme)}
+	host := u.Hostname()
+	if isPattern && strings.HasPrefix(host, "*.") {
+		o.wildcard = true
+		host = host[2:]
+	}
+	if host == "" {
+		return origin{}, false
+	}
+
+	if strings.Contains(host, ":") {
+		// IPv6 literal
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return origin{}, false
+		}
+		o.host = ip.String()
+	} else {
+		// browsers always send ASCII hosts, only patterns are converted
+		if !isPattern && !isASCII(host) {
+			return origin{}, false
+		}
+		o.host, err = hostToASCII(host)
+		if err != nil {
+			return origin{}, false
+		}
+	}
+
+	o.port = u.Port()
+	if o.port == "" {
+		o.port = defaultPort(o.scheme)
+		if o.port == "" {
+			return origin{}, false
+		}
+	}
+	return o, true
+}
+
+// matches reports whether the origin o matches the pattern p.
+func (p origin) matches(o origin) bool {
+	if p.scheme != o.scheme || p.port != o.port {
+		return false
+	}
+	if p.wildcard {
+		// subdomains only, not the domain itself
+		return strings.HasSuffix(o.host, "."+p.host)
+	}
+	return p.host == o.host
+}
+
+// checkSameOrigin checks if the origin matchs the host.
+// returns True if no origin header was found, it's implied in this case
+// that the request was not made from a browser.
+//
+// The request is assumed to use the default port of the origin's scheme
+// if the Host header has no port, unless the connection used TLS then it MUST be https.
+func checkSameOrigin(r *http.Request) bool {
+	values := r.Header["Origin"]
+	if len(values) == 0 {
+		// No origin header so we can assume the client is not a browser.
+		return true
+	}
+	o, ok := parseOrigin(values[0], false)
+	if !ok {
+		return false
+	}
+	if r.TLS != nil && o.scheme != "https" {
+		return false
+	}
+
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		// JoinHostPort adds the brackets back to IPv6 literals
+		host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		port = defaultPort(o.scheme)
+	}
+	self, ok := parseOrigin(o.scheme+"://"+net.JoinHostPort(host, port), false)
+	if !ok {
+		return false
+	}
+	return self.matches(o)
+}
+
+// checkAllowedOrigins checks the origin against the patterns in allowed,
+// returns True if no origin header was found same as checkSameOrigin.
+func checkAllowedOrigins(r *http.Request, allowed []string) bool {
+	values := r.Header["Origin"]
+	if len(values) == 0 {
+		return true
+	}
+	o, ok := parseOrigin(values[0], false)
+	if !ok {
+		return false
+	}
+
+	for _, s := range allowed {
+		// invalid patterns never match
+		p, ok := parseOrigin(s, true)
+		if ok && p.matches(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCSRFToken checks the double submit token, the cookie named cookieName
+// MUST be equal to the query parameter named param.
+func checkCSRFToken(r *http.Request, cookieName, param string) bool {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	token := r.URL.Query().Get(param)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// hostToASCII lowercases host and converts any non ASCII label to punycode,
+// no other IDNA mapping is done.
+func hostToASCII(host string) (string, error) {
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		encoded, err := punycodeEncode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// Punycode parameters as defined in RFC 3492 section 5.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+var errPunycode = errors.New("websocket: invalid punycode input")
+
+// punycodeEncode encodes s as defined in RFC 3492 section 6.3.
+func punycodeEncode(s string) (string, error) {
+	runes := []rune(s)
+	out := make([]byte, 0, len(s)+8)
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if b > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for h < len(runes) {
+		// the smallest code point not handled yet
+		m := int(^uint32(0) >> 1)
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		if (m - n) > (int(^uint32(0)>>1)-delta)/(h+1) {
+			return "", errPunycode
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				t = max(punyTMin, min(t, punyTMax))
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"runtime"
+)
+
+var (
+	ErrPollerUnsupported = errors.New("websocket: netpoller is only supported on linux")
+	ErrPollerClosed      = errors.New("websocket: netpoller is closed")
+	ErrPollerConn        = errors.New("websocket: connection can't be registered with the netpoller")
+)
+
+// PollHandler is called by the [Poller] workers each time a full message is read from a [*Conn].
+//
+// The arguments are the same values returned by [Conn.NextMessage],
+// if err is not nil the connection was already removed from the poller
+// and the handler won't be called again for it.
+type PollHandler func(c *Conn, mt Opcode, payload []byte, err error)
+
+// PollerConfig is used to configure a [Poller].
+type PollerConfig struct {
+	// Workers is the number of goroutines running the handlers,
+	// if not assigned the default is [runtime.NumCPU].
+	Workers int
+
+	// MaxMessageSize is the maximum number of bytes buffered for a single message,
+	// connections exceeding it are closed with [CloseFrameTooBig].
+	// if not assigned the default is 16MB.
+	MaxMessageSize int
+}
+
+func (cfg *PollerConfig) workers() int {
+	if cfg.Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return cfg.Workers
+}
+
+func (cfg *PollerConfig) maxMessageSize() int {
+	if cfg.MaxMessageSize <= 0 {
+		return 16 << 20
+	}
+	return cfg.MaxMessageSize
+}
+
+type scanResult int
+
+const (
+	// not enough bytes buffered yet
+	scanIncomplete scanResult = iota
+	// a ping or pong frame is at the head of the buffer
+	scanControl
+	// a full message (or a close frame) is buffered
+	scanMessage
+	// the buffered message exceeds the max message size
+	scanTooBig
+)
+
+// scanFrames walks the frame headers in buf and reports
+// whether [Conn.NextMessage] can run without blocking on the network.
+func scanFrames(buf []byte, maxSize int) scanResult {
+	off := 0
+	first := true
+	for {
+		h, n, ok := peekFrameHeaders(buf[off:])
+		if !ok {
+			return scanIncomplete
+		}
+		if h.PayloadLength > uint64(maxSize) || off+n+int(h.PayloadLength) > maxSize {
+			return scanTooBig
+		}
+		end := off + n + int(h.PayloadLength)
+		if end > len(buf) {
+			return scanIncomplete
+		}
+		off = end
+
+		switch {
+		case isPingPongFrame(h.Opcode):
+			if first {
+				return scanControl
+			}
+		case h.Opcode == CloseFrame:
+			return scanMessage
+		case h.FIN || !isDataFrame(h.Opcode) && h.Opcode != ContinuationFrame:
+			// invalid opcodes are left for NextMessage to fail the connection
+			return scanMessage
+		}
+		first = false
+	}
+}
+
+// peekFrameHeaders is the non consuming version of [Conn.parseFrameHeaders],
+// it returns the headers and their length on the wire.
+func peekFrameHeaders(buf []byte) (*Headers, int, bool) {
+	if len(buf) < 2 {
+		return nil, 0, false
+	}
+
+	h := &Headers{
+		FIN:           readToBool(buf[0], finMask),
+		Opcode:        Opcode(buf[0] & opcodeMask),
+		Mask:          readToBool(buf[1], maskMask),
+		PayloadLength: uint64(buf[1] & payloadLengthMask),
+	}
+
+	n := 2
+	switch h.PayloadLength {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h.Mask {
+		n += 4
+	}
+	if len(buf) < n {
+		return nil, 0, false
+	}
+
+	switch h.PayloadLength {
+	case 126:
+		h.PayloadLength = uint64(binary.BigEndian.Uint16(buf[2:4]))
+	case 127:
+		h.PayloadLength = binary.BigEndian.Uint64(buf[2:10])
+	}
+
+	return h, n, true
+}
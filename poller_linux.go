@@ -0,0 +1,462 @@
+//go:build linux
+
+package websocket
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+)
+
+// Poller serves many connections from a small pool of goroutines using epoll,
+// instead of having a goroutine blocked in [Conn.NextMessage] for every connection.
+//
+// Connections are registered after the handshake with [Poller.Add],
+// and the handler is only called when a full message was received.
+// TLS connections aren't supported as the poller reads the raw socket.
+type Poller struct {
+	cfg     PollerConfig
+	handler PollHandler
+
+	epfd int
+	// pipe used to wake up epoll_wait on Close
+	wakeR, wakeW int
+
+	// mu guards the maps and pollConn.removed
+	mu     sync.Mutex
+	conns  map[int]*pollConn
+	byConn map[*Conn]*pollConn
+	closed bool
+
+	work chan *pollConn
+	wg   sync.WaitGroup
+}
+
+// pollConn is the poller state of a registered connection.
+type pollConn struct {
+	mu sync.Mutex
+
+	c   *Conn
+	fd  int
+	raw syscall.RawConn
+
+	// unread bytes received from the socket
+	pending []byte
+	off     int
+
+	// armed is whether the fd was added to epoll
+	armed bool
+	// removed is guarded by Poller.mu
+	removed bool
+}
+
+// Read serves the [Conn] reads from the pending bytes.
+func (pc *pollConn) Read(p []byte) (int, error) {
+	if pc.off == len(pc.pending) {
+		return 0, io.EOF
+	}
+	n := copy(p, pc.pending[pc.off:])
+	pc.off += n
+	return n, nil
+}
+
+// unbuffer moves back any bytes buffered by the [Conn] bufio reader,
+// so the pending bytes hold every unread byte.
+func (pc *pollConn) unbuffer() {
+	n := pc.c.br.Buffered()
+	if n > 0 {
+		buffered, _ := pc.c.br.Peek(n)
+		unread := make([]byte, 0, n+len(pc.pending)-pc.off)
+		unread = append(unread, buffered...)
+		unread = append(unread, pc.pending[pc.off:]...)
+		pc.pending = unread
+		pc.off = 0
+	}
+	pc.c.br.Reset(pc)
+}
+
+// fill reads everything currently available on the socket without blocking,
+// it returns io.EOF if the peer closed the connection.
+func (pc *pollConn) fill() error {
+	// compact consumed bytes
+	if pc.off > 0 {
+		pc.pending = append(pc.pending[:0], pc.pending[pc.off:]...)
+		pc.off = 0
+	}
+
+	for {
+		if cap(pc.pending)-len(pc.pending) < 4096 {
+			pc.pending = append(pc.pending, make([]byte, 4096)...)[:len(pc.pending)]
+		}
+		buf := pc.pending[len(pc.pending):cap(pc.pending)]
+
+		var n int
+		var readErr error
+		err := pc.raw.Read(func(fd uintptr) bool {
+			n, readErr = syscall.Read(int(fd), buf)
+			// never wait for readiness, that's the poller's job
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if readErr == syscall.EINTR {
+			continue
+		}
+		if readErr == syscall.EAGAIN {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+		if n == 0 {
+			return io.EOF
+		}
+		pc.pending = pc.pending[:len(pc.pending)+n]
+		if n < len(buf) {
+			return nil
+		}
+	}
+}
+
+// NewPoller creates a [Poller] and starts its event loop and workers,
+// the handler is called for every message received on the registered connections.
+func NewPoller(cfg PollerConfig, handler PollHandler) (*Poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	var wake [2]int
+	err = syscall.Pipe2(wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC)
+	if err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, wake[0], &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(wake[0]),
+	})
+	if err != nil {
+		syscall.Close(epfd)
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+		return nil, err
+	}
+
+	p := &Poller{
+		cfg:     cfg,
+		handler: handler,
+		epfd:    epfd,
+		wakeR:   wake[0],
+		wakeW:   wake[1],
+		conns:   make(map[int]*pollConn),
+		byConn:  make(map[*Conn]*pollConn),
+		work:    make(chan *pollConn, cfg.workers()),
+	}
+
+	for range cfg.workers() {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	p.wg.Add(1)
+	go p.loop()
+
+	return p, nil
+}
+
+// Add registers the connection with the poller,
+// after that the connection MUST NOT be read from outside of the handler.
+//
+// The connection is removed automatically when it's closed,
+// or you can remove it with [Poller.Remove] to go back to calling [Conn.NextMessage].
+func (p *Poller) Add(c *Conn) error {
+	sc, ok := c.netConn.(syscall.Conn)
+	if !ok {
+		return ErrPollerConn
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	err = raw.Control(func(s uintptr) {
+		fd = int(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPollerClosed
+	}
+	if _, ok := p.byConn[c]; ok {
+		p.mu.Unlock()
+		return ErrPollerConn
+	}
+
+	pc := &pollConn{
+		c:   c,
+		fd:  fd,
+		raw: raw,
+	}
+	// bytes read before the connection got registered
+	pc.unbuffer()
+	// epoll only reports new bytes, so serve the buffered frames right away,
+	// serve arms the connection once it's done.
+	serveNow := scanFrames(pc.pending, p.cfg.maxMessageSize()) != scanIncomplete
+
+	p.conns[fd] = pc
+	p.byConn[c] = pc
+	if serveNow {
+		p.wg.Add(1)
+	}
+	p.mu.Unlock()
+
+	// the kernel drops a closed fd from epoll without an event
+	c.addOnClose(func() { p.remove(pc, false) })
+
+	if serveNow {
+		go func() {
+			defer p.wg.Done()
+			p.serve(pc)
+		}()
+		return nil
+	}
+
+	// set before the fd is live in epoll, pc.mu isn't held
+	pc.armed = true
+	err = syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(fd),
+	})
+	if err != nil {
+		p.remove(pc, false)
+		pc.giveBack()
+		return err
+	}
+
+	return nil
+}
+
+// arm waits for the next read event on the connection, pc.mu must be held.
+func (p *Poller) arm(pc *pollConn) error {
+	op := syscall.EPOLL_CTL_MOD
+	if !pc.armed {
+		op = syscall.EPOLL_CTL_ADD
+	}
+	err := syscall.EpollCtl(p.epfd, op, pc.fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+		Fd:     int32(pc.fd),
+	})
+	if err != nil {
+		return err
+	}
+	pc.armed = true
+	return nil
+}
+
+// Remove unregisters the connection from the poller,
+// any bytes buffered by the poller are kept for the next [Conn.NextMessage] call.
+//
+// It can be called from the handler, the handler isn't called again for the connection.
+// If it's called from another goroutine the handler might still be running for a message read before.
+func (p *Poller) Remove(c *Conn) error {
+	p.mu.Lock()
+	pc := p.byConn[c]
+	p.mu.Unlock()
+
+	if pc == nil {
+		return ErrPollerConn
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if !p.remove(pc, true) {
+		return ErrPollerConn
+	}
+	pc.giveBack()
+	return nil
+}
+
+// giveBack gives the unread bytes back to the connection, pc.mu must be held.
+func (pc *pollConn) giveBack() {
+	pc.c.br = newBufioReader(pc.pending[pc.off:], pc.c.netConn, 0)
+}
+
+// remove unregisters the connection, it returns false if it was already removed.
+// The fd is deleted from epoll if del is true, a closed fd is already dropped by the kernel
+// and its number might be reused by another connection.
+func (p *Poller) remove(pc *pollConn, del bool) bool {
+	p.mu.Lock()
+	if pc.removed {
+		p.mu.Unlock()
+		return false
+	}
+	pc.removed = true
+	if p.conns[pc.fd] == pc {
+		delete(p.conns, pc.fd)
+	}
+	delete(p.byConn, pc.c)
+	p.mu.Unlock()
+
+	if del {
+		_ = syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, pc.fd, nil)
+	}
+	return true
+}
+
+func (p *Poller) isRemoved(pc *pollConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return pc.removed
+}
+
+// Close stops the event loop and waits for the running handlers to return.
+//
+// Registered connections are removed but not closed, same as calling [Poller.Remove] for each one.
+func (p *Poller) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPollerClosed
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	// wake up epoll_wait
+	_, _ = syscall.Write(p.wakeW, []byte{0})
+	p.wg.Wait()
+
+	p.mu.Lock()
+	pcs := make([]*pollConn, 0, len(p.byConn))
+	for _, pc := range p.byConn {
+		pcs = append(pcs, pc)
+	}
+	p.mu.Unlock()
+	for _, pc := range pcs {
+		pc.mu.Lock()
+		if p.remove(pc, true) {
+			pc.giveBack()
+		}
+		pc.mu.Unlock()
+	}
+
+	syscall.Close(p.wakeR)
+	syscall.Close(p.wakeW)
+	return syscall.Close(p.epfd)
+}
+
+func (p *Poller) loop() {
+	defer p.wg.Done()
+	// workers exit when the loop is done
+	defer close(p.work)
+
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return
+		}
+
+		for _, ev := range events[:n] {
+			fd := int(ev.Fd)
+			if fd == p.wakeR {
+				return
+			}
+
+			p.mu.Lock()
+			pc, ok := p.conns[fd]
+			p.mu.Unlock()
+			if ok {
+				p.work <- pc
+			}
+		}
+	}
+}
+
+func (p *Poller) worker() {
+	defer p.wg.Done()
+	for pc := range p.work {
+		p.serve(pc)
+	}
+}
+
+// serve reads the available bytes and calls the handler for every full message,
+// then re-arms the connection in epoll.
+//
+// pc.mu isn't held while the handler runs so it can call [Poller.Remove].
+func (p *Poller) serve(pc *pollConn) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if p.isRemoved(pc) {
+		return
+	}
+
+	// handle calls the handler without holding pc.mu,
+	// it returns false if the connection was removed meanwhile.
+	handle := func(mt Opcode, payload []byte, err error) bool {
+		pc.mu.Unlock()
+		p.handler(pc.c, mt, payload, err)
+		pc.mu.Lock()
+		return !p.isRemoved(pc)
+	}
+
+	c := pc.c
+	fillErr := pc.fill()
+	if fillErr != nil && !isEOF(fillErr) {
+		p.remove(pc, true)
+		c.closeConnCause(fillErr)
+		handle(CloseFrame, nil, fillErr)
+		return
+	}
+
+scan:
+	for {
+		switch scanFrames(pc.pending[pc.off:], p.cfg.maxMessageSize()) {
+		case scanIncomplete:
+			break scan
+		case scanControl:
+			err := c.nextControlFrame()
+			pc.unbuffer()
+			if err != nil {
+				p.remove(pc, true)
+				_, _, err = c.handleSingleFrameErr(err)
+				handle(CloseFrame, nil, err)
+				return
+			}
+		case scanMessage:
+			mt, payload, err := c.NextMessage()
+			pc.unbuffer()
+			if err != nil {
+				p.remove(pc, true)
+			}
+			if !handle(mt, payload, err) {
+				return
+			}
+		case scanTooBig:
+			p.remove(pc, true)
+			_, _, err := c.closeWithErr(CloseFrameTooBig)
+			handle(CloseFrame, nil, err)
+			return
+		}
+	}
+
+	if isEOF(fillErr) {
+		p.remove(pc, true)
+		c.closeConnCause(ErrUnexpectedClose)
+		handle(CloseFrame, nil, ErrUnexpectedClose)
+		return
+	}
+
+	if p.arm(pc) != nil {
+		// the connection got closed by the handler
+		p.remove(pc, true)
+	}
+}
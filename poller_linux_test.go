@@ -0,0 +1,235 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// pollEvent is a call of the poll handler.
+type pollEvent struct {
+	c       *Conn
+	payload string
+	err     error
+}
+
+// dialPolled upgrades a raw client connection and adds the server side to p,
+// first is written along with the handshake.
+func dialPolled(t *testing.T, p *Poller, first []byte) (*Conn, net.Conn, *bufio.Reader) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	netConn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Write(append([]byte(testHandshake), first...)); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := (&Upgrader{}).UpgradeConn(netConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.closeConn() })
+
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	if err := p.Add(c); err != nil {
+		t.Fatal(err)
+	}
+	return c, client, br
+}
+
+func newTestPoller(t *testing.T, cfg PollerConfig, handler PollHandler) *Poller {
+	t.Helper()
+	p, err := NewPoller(cfg, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func waitEvent(t *testing.T, events <-chan pollEvent) pollEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the poll handler")
+		return pollEvent{}
+	}
+}
+
+func TestPollerEcho(t *testing.T) {
+	p := newTestPoller(t, PollerConfig{Workers: 2}, func(c *Conn, mt Opcode, payload []byte, err error) {
+		if err == nil {
+			c.SendMessage(payload, mt)
+		}
+	})
+	_, client, br := dialPolled(t, p, nil)
+
+	for _, msg := range []string{"hello", "world"} {
+		if _, err := client.Write(clientFrame(TextMessage, msg)); err != nil {
+			t.Fatal(err)
+		}
+		op, payload := readServerFrame(t, br)
+		if op != TextMessage || string(payload) != msg {
+			t.Fatalf("got %v %q, want %v %q", op, payload, TextMessage, msg)
+		}
+	}
+}
+
+func TestPollerBufferedBeforeAdd(t *testing.T) {
+	events := make(chan pollEvent, 4)
+	p := newTestPoller(t, PollerConfig{}, func(c *Conn, mt Opcode, payload []byte, err error) {
+		events <- pollEvent{c, string(payload), err}
+	})
+
+	// both frames are read by the handshake before the connection is added
+	first := append(clientFrame(TextMessage, "one"), clientFrame(TextMessage, "two")...)
+	dialPolled(t, p, first)
+
+	for _, want := range []string{"one", "two"} {
+		ev := waitEvent(t, events)
+		if ev.err != nil || ev.payload != want {
+			t.Fatalf("got %q, %v, want %q", ev.payload, ev.err, want)
+		}
+	}
+}
+
+func TestPollerRemoveFromHandler(t *testing.T) {
+	var p *Poller
+	events := make(chan pollEvent, 4)
+	p = newTestPoller(t, PollerConfig{}, func(c *Conn, mt Opcode, payload []byte, err error) {
+		if err == nil && string(payload) == "remove" {
+			err = p.Remove(c)
+		}
+		events <- pollEvent{c, string(payload), err}
+	})
+
+	// the second frame is pending in the poller when the handler removes the connection
+	first := append(clientFrame(TextMessage, "remove"), clientFrame(TextMessage, "after")...)
+	c, _, _ := dialPolled(t, p, first)
+
+	ev := waitEvent(t, events)
+	if ev.err != nil || ev.payload != "remove" {
+		t.Fatalf("got %q, %v, want %q", ev.payload, ev.err, "remove")
+	}
+
+	_, payload, err := c.NextMessage()
+	if err != nil || string(payload) != "after" {
+		t.Fatalf("NextMessage() = %q, %v, want %q", payload, err, "after")
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("handler called after Remove: %q, %v", ev.payload, ev.err)
+	default:
+	}
+	if err := p.Remove(c); !errors.Is(err, ErrPollerConn) {
+		t.Fatalf("second Remove() = %v, want %v", err, ErrPollerConn)
+	}
+}
+
+func TestPollerMaxMessageSize(t *testing.T) {
+	events := make(chan pollEvent, 1)
+	p := newTestPoller(t, PollerConfig{MaxMessageSize: 16}, func(c *Conn, mt Opcode, payload []byte, err error) {
+		events <- pollEvent{c, string(payload), err}
+	})
+	_, client, br := dialPolled(t, p, nil)
+
+	if _, err := client.Write(clientFrame(BinaryMessage, string(make([]byte, 32)))); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := waitEvent(t, events)
+	if ev.err == nil {
+		t.Fatal("expected an error for a message over MaxMessageSize")
+	}
+	op, payload := readServerFrame(t, br)
+	if op != CloseFrame || len(payload) < 2 || binary.BigEndian.Uint16(payload) != CloseFrameTooBig {
+		t.Fatalf("got %v %v, want a close frame with %d", op, payload, CloseFrameTooBig)
+	}
+}
+
+func TestPollerPeerEOF(t *testing.T) {
+	events := make(chan pollEvent, 1)
+	p := newTestPoller(t, PollerConfig{}, func(c *Conn, mt Opcode, payload []byte, err error) {
+		events <- pollEvent{c, string(payload), err}
+	})
+	c, client, _ := dialPolled(t, p, nil)
+
+	client.Close()
+	ev := waitEvent(t, events)
+	if !errors.Is(ev.err, ErrUnexpectedClose) {
+		t.Fatalf("err = %v, want %v", ev.err, ErrUnexpectedClose)
+	}
+	select {
+	case <-c.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection context not cancelled")
+	}
+	if cause := context.Cause(c.Context()); !errors.Is(cause, ErrUnexpectedClose) {
+		t.Fatalf("cause = %v, want %v", cause, ErrUnexpectedClose)
+	}
+}
+
+func TestPollerClosedOutsideHandler(t *testing.T) {
+	p := newTestPoller(t, PollerConfig{}, func(c *Conn, mt Opcode, payload []byte, err error) {})
+	c, _, _ := dialPolled(t, p, nil)
+
+	c.Close()
+
+	p.mu.Lock()
+	n := len(p.byConn) + len(p.conns)
+	p.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("closed connection still registered")
+	}
+	if err := p.Remove(c); !errors.Is(err, ErrPollerConn) {
+		t.Fatalf("Remove() = %v, want %v", err, ErrPollerConn)
+	}
+}
+
+func TestPollerCloseGivesBackConns(t *testing.T) {
+	p, err := NewPoller(PollerConfig{}, func(c *Conn, mt Opcode, payload []byte, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := clientFrame(TextMessage, "split across the close")
+	// half a frame is buffered by the poller when it's closed
+	c, client, _ := dialPolled(t, p, frame[:10])
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(frame[10:]); err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err := c.NextMessage()
+	if err != nil || string(payload) != "split across the close" {
+		t.Fatalf("NextMessage() = %q, %v", payload, err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package websocket
+
+// Poller is only supported on linux, on other platforms [NewPoller] returns [ErrPollerUnsupported].
+type Poller struct{}
+
+// NewPoller always returns [ErrPollerUnsupported] on this platform.
+func NewPoller(cfg PollerConfig, handler PollHandler) (*Poller, error) {
+	return nil, ErrPollerUnsupported
+}
+
+func (p *Poller) Add(c *Conn) error {
+	return ErrPollerUnsupported
+}
+
+func (p *Poller) Remove(c *Conn) error {
+	return ErrPollerUnsupported
+}
+
+func (p *Poller) Close() error {
+	return ErrPollerUnsupported
+}
@@ -0,0 +1,293 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrProxyScheme = errors.New("websocket: unsupported proxy scheme")
+	ErrProxyAuth   = errors.New("websocket: proxy authentication failed")
+)
+
+// dialProxy dials the proxy and opens a tunnel to addr through it.
+func (d *Dialer) dialProxy(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrProxyScheme, proxyURL.Scheme)
+	}
+
+	// socks5 resolves the host name locally, socks5h leaves it to the proxy
+	if proxyURL.Scheme == "socks5" {
+		var err error
+		addr, err = resolveAddr(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	netConn, err := d.dial(ctx, "tcp", hostPort(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL.Scheme == "https" {
+		var cfg *tls.Config
+		if d.TlsConfig != nil {
+			cfg = d.TlsConfig.Clone()
+		} else {
+			cfg = &tls.Config{}
+		}
+		cfg.ServerName = proxyURL.Hostname()
+		tlsConn := tls.Client(netConn, cfg)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		netConn = tlsConn
+	}
+
+	// bound the proxy handshake by the context
+	stop := watchContext(ctx, netConn)
+	if proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h" {
+		err = socks5Connect(netConn, proxyURL.User, addr)
+	} else {
+		err = httpConnect(netConn, proxyURL.User, addr)
+	}
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+
+	return netConn, nil
+}
+
+// resolveAddr replaces the host name in addr with its first resolved ip.
+func resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].IP.String(), port), nil
+}
+
+// httpConnect opens a tunnel to addr with an HTTP CONNECT request.
+func httpConnect(netConn net.Conn, user *url.Userinfo, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	err := req.Write(netConn)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(netConn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return err
+	}
+	// a successful CONNECT response has no body, the tunnel starts right after the headers
+	if res.StatusCode == http.StatusProxyAuthRequired {
+		return ErrProxyAuth
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("websocket: proxy CONNECT failed: %s", res.Status)
+	}
+	// the proxy MUST NOT send anything before the tunneled server does
+	if br.Buffered() > 0 {
+		return errors.New("websocket: proxy sent unexpected data after CONNECT")
+	}
+
+	return nil
+}
+
+// SOCKS5 constants as defined in RFC 1928 and RFC 1929.
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoMethod = 0xff
+
+	socks5PasswordVersion = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socks5Connect opens a tunnel to addr through a SOCKS5 proxy.
+func socks5Connect(netConn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("websocket: invalid port %q", portStr)
+	}
+
+	// method negotiation
+	methods := []byte{socks5AuthNone}
+	if user != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	buf := []byte{socks5Version, byte(len(methods))}
+	buf = append(buf, methods...)
+	if _, err := netConn.Write(buf); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(netConn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("websocket: unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return ErrProxyAuth
+		}
+		if err := socks5Authenticate(netConn, user); err != nil {
+			return err
+		}
+	case socks5AuthNoMethod:
+		return ErrProxyAuth
+	default:
+		return fmt.Errorf("websocket: unsupported SOCKS auth method %d", reply[1])
+	}
+
+	// connect request
+	buf = []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append(buf, socks5AddrIPv4)
+			buf = append(buf, ip4...)
+		} else {
+			buf = append(buf, socks5AddrIPv6)
+			buf = append(buf, ip.To16()...)
+		}
+	} else {
+		// socks5h, the proxy resolves the host name
+		if len(host) > 255 {
+			return fmt.Errorf("websocket: host name too long %q", host)
+		}
+		buf = append(buf, socks5AddrDomain, byte(len(host)))
+		buf = append(buf, host...)
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(port))
+	if _, err := netConn.Write(buf); err != nil {
+		return err
+	}
+
+	// reply: version, status, reserved, address type
+	reply = make([]byte, 4)
+	if _, err := io.ReadFull(netConn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("websocket: unexpected SOCKS version %d", reply[0])
+	}
+	if reply[1] != 0x00 {
+		msg, ok := socks5Replies[reply[1]]
+		if !ok {
+			msg = "unknown error " + strconv.Itoa(int(reply[1]))
+		}
+		return fmt.Errorf("websocket: SOCKS proxy connect failed: %s", msg)
+	}
+
+	// discard the bound address and port
+	var n int
+	switch reply[3] {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(netConn, l); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return fmt.Errorf("websocket: unexpected SOCKS address type %d", reply[3])
+	}
+	_, err = io.ReadFull(netConn, make([]byte, n+2))
+	return err
+}
+
+// socks5Authenticate does the username/password authentication defined in RFC 1929.
+func socks5Authenticate(netConn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return ErrProxyAuth
+	}
+
+	buf := []byte{socks5PasswordVersion, byte(len(username))}
+	buf = append(buf, username...)
+	buf = append(buf, byte(len(password)))
+	buf = append(buf, password...)
+	if _, err := netConn.Write(buf); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(netConn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return ErrProxyAuth
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHTTPConnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     *url.Userinfo
+		response string
+		wantAuth string
+		wantErr  error
+	}{
+		{"no auth", nil, "HTTP/1.1 200 OK\r\n\r\n", "", nil},
+		{"auth", url.UserPassword("user", "pass"), "HTTP/1.1 200 Connection established\r\n\r\n", "Basic dXNlcjpwYXNz", nil},
+		{"auth required", nil, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n", "", ErrProxyAuth},
+		{"wrong credentials", url.UserPassword("user", "wrong"), "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n", "Basic dXNlcjp3cm9uZw==", ErrProxyAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, proxy := net.Pipe()
+			defer client.Close()
+			defer proxy.Close()
+
+			reqs := make(chan *http.Request, 1)
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(proxy))
+				reqs <- req
+				if err == nil {
+					io.WriteString(proxy, tt.response)
+				}
+			}()
+
+			err := httpConnect(client, tt.user, "example.com:443")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+
+			req := <-reqs
+			if req == nil {
+				t.Fatal("proxy didn't receive a request")
+			}
+			if req.Method != http.MethodConnect || req.RequestURI != "example.com:443" {
+				t.Fatalf("request = %s %s, want CONNECT example.com:443", req.Method, req.RequestURI)
+			}
+			if got := req.Header.Get("Proxy-Authorization"); got != tt.wantAuth {
+				t.Fatalf("Proxy-Authorization = %q, want %q", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+// socks5Request is what a fake SOCKS5 proxy received.
+type socks5Request struct {
+	methods  []byte
+	username string
+	password string
+	addrType byte
+	host     string
+	port     uint16
+}
+
+// serveSocks5 runs a fake SOCKS5 proxy on conn, it requires password authentication
+// when password isn't empty.
+func serveSocks5(conn net.Conn, password string) (*socks5Request, error) {
+	r := &socks5Request{}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	r.methods = make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, r.methods); err != nil {
+		return nil, err
+	}
+
+	if password == "" {
+		if _, err := conn.Write([]byte{socks5Version, socks5AuthNone}); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write([]byte{socks5Version, socks5AuthPassword}); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		username := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, username); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		passwd := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, passwd); err != nil {
+			return nil, err
+		}
+		r.username, r.password = string(username), string(passwd)
+
+		status := byte(0x00)
+		if r.password != password {
+			status = 0x01
+		}
+		if _, err := conn.Write([]byte{socks5PasswordVersion, status}); err != nil {
+			return nil, err
+		}
+		if status != 0x00 {
+			return r, nil
+		}
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	r.addrType = head[3]
+	var host []byte
+	switch r.addrType {
+	case socks5AddrIPv4:
+		host = make([]byte, net.IPv4len)
+	case socks5AddrIPv6:
+		host = make([]byte, net.IPv6len)
+	case socks5AddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		host = make([]byte, buf[0])
+	}
+	if _, err := io.ReadFull(conn, host); err != nil {
+		return nil, err
+	}
+	if r.addrType == socks5AddrDomain {
+		r.host = string(host)
+	} else {
+		r.host = net.IP(host).String()
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	r.port = binary.BigEndian.Uint16(buf)
+
+	_, err := conn.Write([]byte{socks5Version, 0x00, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return r, err
+}
+
+func TestSocks5Connect(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     *url.Userinfo
+		password string
+		wantErr  error
+	}{
+		{"no auth", nil, "", nil},
+		{"auth offered but not required", url.UserPassword("user", "pass"), "", nil},
+		{"auth", url.UserPassword("user", "pass"), "pass", nil},
+		{"wrong password", url.UserPassword("user", "wrong"), "pass", ErrProxyAuth},
+		{"auth required", nil, "pass", ErrProxyAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, proxy := net.Pipe()
+			defer client.Close()
+			defer proxy.Close()
+
+			reqs := make(chan *socks5Request, 1)
+			go func() {
+				r, _ := serveSocks5(proxy, tt.password)
+				reqs <- r
+			}()
+
+			err := socks5Connect(client, tt.user, "example.com:8080")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			client.Close()
+
+			r := <-reqs
+			if tt.wantErr != nil {
+				return
+			}
+			if r == nil {
+				t.Fatal("proxy didn't receive a request")
+			}
+			if tt.password != "" && (r.username != "user" || r.password != tt.password) {
+				t.Fatalf("credentials = %q:%q, want user:%s", r.username, r.password, tt.password)
+			}
+			if r.addrType != socks5AddrDomain || r.host != "example.com" || r.port != 8080 {
+				t.Fatalf("address = %d %s:%d, want a domain example.com:8080", r.addrType, r.host, r.port)
+			}
+		})
+	}
+}
+
+func TestDialProxySocks5Resolve(t *testing.T) {
+	tests := []struct {
+		scheme     string
+		wantDomain bool
+	}{
+		{"socks5", false},
+		{"socks5h", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			reqs := make(chan *socks5Request, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					reqs <- nil
+					return
+				}
+				defer conn.Close()
+				r, _ := serveSocks5(conn, "")
+				reqs <- r
+			}()
+
+			proxyURL := &url.URL{Scheme: tt.scheme, Host: ln.Addr().String()}
+			netConn, err := (&Dialer{}).dialProxy(t.Context(), proxyURL, "localhost:8080")
+			if err != nil {
+				t.Fatal(err)
+			}
+			netConn.Close()
+
+			r := <-reqs
+			if r == nil {
+				t.Fatal("proxy didn't receive a request")
+			}
+			if tt.wantDomain {
+				if r.addrType != socks5AddrDomain || r.host != "localhost" {
+					t.Fatalf("address = %d %s, want the domain localhost", r.addrType, r.host)
+				}
+			} else if r.addrType == socks5AddrDomain || !net.ParseIP(r.host).IsLoopback() {
+				t.Fatalf("address = %d %s, want a loopback ip", r.addrType, r.host)
+			}
+			if r.port != 8080 {
+				t.Fatalf("port = %d, want 8080", r.port)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSendQueueFull   = errors.New("websocket: send queue is full")
+	ErrSendQueueClosed = errors.New("websocket: send queue is closed")
+	ErrMessageDropped  = errors.New("websocket: message dropped from the send queue")
+)
+
+// QueuePolicy is what [Conn.Enqueue] does when the send queue is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock blocks the caller until there's space in the queue.
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest drops the oldest queued message to make space for the new one.
+	QueueDropOldest
+	// QueueDropNewest drops the new message and returns [ErrSendQueueFull].
+	QueueDropNewest
+	// QueueClose closes the connection with [ClosePolicyViolation] and returns [ErrSendQueueFull],
+	// the queued messages are dropped.
+	QueueClose
+)
+
+// queueCloseTimeout is how long [QueueClose] waits for the close frame to be written,
+// the writer might be stuck on a slow socket.
+const queueCloseTimeout = 5 * time.Second
+
+// SendQueueConfig is used to configure the per connection send queue used by [Conn.Enqueue].
+type SendQueueConfig struct {
+	// Size is the maximum number of queued messages,
+	// if not assigned the default is 64.
+	Size int
+
+	// Policy is what to do when the queue is full, the default is [QueueBlock].
+	Policy QueuePolicy
+}
+
+// SendFuture reports when a queued message was written to the socket.
+type SendFuture struct {
+	done chan struct{}
+	err  error
+	fn   func(err error)
+}
+
+// Done returns a channel that's closed when the message was written or dropped.
+func (f *SendFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the message was written or dropped,
+// and returns the error if writing it failed.
+func (f *SendFuture) Wait() error {
+	<-f.done
+	return f.err
+}
+
+func (f *SendFuture) complete(err error) {
+	f.err = err
+	close(f.done)
+	if f.fn != nil {
+		f.fn(err)
+	}
+}
+
+type queuedMessage struct {
+	payload []byte
+	mt      Opcode
+	future  *SendFuture
+}
+
+// sendQueue is a bounded queue of messages drained by a single writer goroutine.
+type sendQueue struct {
+	c    *Conn
+	size int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	items    []*queuedMessage
+	buffered uint64
+	closed   bool
+	err      error
+}
+
+func newSendQueue(c *Conn, cfg SendQueueConfig) *sendQueue {
+	size := cfg.Size
+	if size <= 0 {
+		size = 64
+	}
+	sq := &sendQueue{
+		c:    c,
+		size: size,
+	}
+	sq.cond = sync.NewCond(&sq.mu)
+
+	go sq.writer()
+	return sq
+}
+
+// push adds a message to the queue according to the configured policy.
+func (sq *sendQueue) push(m *queuedMessage, policy QueuePolicy) error {
+	sq.mu.Lock()
+
+	for !sq.closed && len(sq.items) >= sq.size {
+		switch policy {
+		case QueueDropOldest:
+			oldest := sq.items[0]
+			sq.items = sq.items[1:]
+			sq.buffered -= uint64(len(oldest.payload))
+			// don't run callbacks while holding the lock
+			sq.mu.Unlock()
+			oldest.future.complete(ErrMessageDropped)
+			sq.mu.Lock()
+		case QueueDropNewest:
+			sq.mu.Unlock()
+			return ErrSendQueueFull
+		case QueueClose:
+			sq.mu.Unlock()
+			sq.close(ErrPolicyViolation)
+			// the writer holds c.wmu while it's stuck on the socket, so don't wait for the close frame
+			go sq.c.closeWithErr(ClosePolicyViolation)
+			time.AfterFunc(queueCloseTimeout, func() { sq.c.closeConnCause(ErrPolicyViolation) })
+			return ErrSendQueueFull
+		default:
+			sq.cond.Wait()
+		}
+	}
+
+	if sq.closed {
+		err := sq.err
+		sq.mu.Unlock()
+		return err
+	}
+
+	sq.items = append(sq.items, m)
+	sq.buffered += uint64(len(m.payload))
+	sq.cond.Broadcast()
+	sq.mu.Unlock()
+	return nil
+}
+
+// writer writes the queued messages in order until the queue is closed.
+func (sq *sendQueue) writer() {
+	for {
+		sq.mu.Lock()
+		for !sq.closed && len(sq.items) == 0 {
+			sq.cond.Wait()
+		}
+		if sq.closed {
+			sq.mu.Unlock()
+			return
+		}
+		m := sq.items[0]
+		sq.items = sq.items[1:]
+		sq.mu.Unlock()
+
+		size := uint64(len(m.payload))
+		_, err := sq.c.SendMessage(m.payload, m.mt)
+
+		// flush coalesced frames once the queue is drained
+		sq.mu.Lock()
+		drained := len(sq.items) == 0
+		sq.mu.Unlock()
+		if err == nil && drained {
+			err = sq.c.Flush()
+		}
+
+		sq.mu.Lock()
+		sq.buffered -= size
+		// wake up blocked producers
+		sq.cond.Broadcast()
+		sq.mu.Unlock()
+
+		m.future.complete(err)
+		if err != nil {
+			sq.close(err)
+			return
+		}
+	}
+}
+
+// close stops the writer and fails every queued message with err.
+func (sq *sendQueue) close(err error) {
+	sq.mu.Lock()
+	if sq.closed {
+		sq.mu.Unlock()
+		return
+	}
+	sq.closed = true
+	sq.err = err
+	items := sq.items
+	sq.items = nil
+	for _, m := range items {
+		sq.buffered -= uint64(len(m.payload))
+	}
+	sq.cond.Broadcast()
+	sq.mu.Unlock()
+
+	for _, m := range items {
+		m.future.complete(err)
+	}
+}
+
+func (c *Conn) sendQueue() (*sendQueue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrSendQueueClosed
+	}
+	// the writer is started on first use
+	if c.sq == nil {
+		c.sq = newSendQueue(c, c.sendQueueConfig)
+	}
+	return c.sq, nil
+}
+
+// Enqueue queues a message to be sent by the connection's writer goroutine without blocking
+// on the network, messages are written in the same order they were queued.
+//
+// The returned [*SendFuture] reports when the message reaches the socket.
+// When the queue is full the configured [QueuePolicy] is applied.
+//
+// The payload MUST NOT be modified until the message is written.
+func (c *Conn) Enqueue(payload []byte, mt Opcode) (*SendFuture, error) {
+	return c.enqueue(payload, mt, nil)
+}
+
+// EnqueueFunc is same as [Conn.Enqueue] except it calls fn when the message was written or dropped,
+// fn is called from the writer goroutine or from the goroutine that dropped the message
+// like an [Conn.Enqueue] call with [QueueDropOldest], so it MUST NOT block.
+func (c *Conn) EnqueueFunc(payload []byte, mt Opcode, fn func(err error)) error {
+	_, err := c.enqueue(payload, mt, fn)
+	return err
+}
+
+func (c *Conn) enqueue(payload []byte, mt Opcode, fn func(err error)) (*SendFuture, error) {
+	if mt != TextMessage && mt != BinaryMessage {
+		return nil, ErrInvalidMessageType
+	}
+
+	sq, err := c.sendQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &queuedMessage{
+		payload: payload,
+		mt:      mt,
+		future: &SendFuture{
+			done: make(chan struct{}),
+			fn:   fn,
+		},
+	}
+	err = sq.push(m, c.sendQueueConfig.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.future, nil
+}
+
+// BufferedAmount returns the number of payload bytes queued with [Conn.Enqueue]
+// that weren't written to the socket yet.
+func (c *Conn) BufferedAmount() uint64 {
+	c.mu.Lock()
+	sq := c.sq
+	c.mu.Unlock()
+	if sq == nil {
+		return 0
+	}
+
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	return sq.buffered
+}
@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"math"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter, it's not safe for concurrent use.
+type tokenBucket struct {
+	// rate is the number of tokens added every second
+	rate  float64
+	burst float64
+
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) tokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return tokenBucket{rate: rate, burst: b, tokens: b}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		elapsed := now.Sub(b.last).Seconds()
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// take removes n tokens from the bucket, if there's not enough tokens
+// nothing is removed and it returns how long until there will be.
+func (b *tokenBucket) take(now time.Time, n float64) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	// a request bigger than the bucket is never satisfied
+	if n > b.burst || b.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := (n - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// isFull reports whether the bucket refilled completely.
+func (b *tokenBucket) isFull(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// reserve always removes n tokens even if the bucket goes below zero,
+// and returns how long until the bucket is back to zero.
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 || b.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// LimitAction is what the connection does when a peer exceeds the [InboundLimitConfig].
+type LimitAction int
+
+const (
+	// LimitThrottle delays reading from the connection until the peer is within the limits again.
+	LimitThrottle LimitAction = iota
+	// LimitDrop reads and drops the message, pings over the limit are not answered.
+	LimitDrop
+	// LimitClose closes the connection with [ClosePolicyViolation].
+	LimitClose
+)
+
+// InboundLimitConfig is used to limit the rate of frames the peer sends on a connection,
+// limits that aren't assigned are disabled, each burst defaults to one second of its rate.
+//
+// With [LimitDrop] and [LimitClose] a frame bigger than BytesBurst always exceeds the limit.
+type InboundLimitConfig struct {
+	// MessagesPerSecond limits the [TextMessage] and [BinaryMessage] messages.
+	MessagesPerSecond float64
+	MessagesBurst     int
+
+	// BytesPerSecond limits the payload bytes of the messages.
+	BytesPerSecond float64
+	BytesBurst     int
+
+	// ControlFramesPerSecond limits the [PingFrame] and [PongFrame] frames.
+	ControlFramesPerSecond float64
+	ControlFramesBurst     int
+
+	// Action is what to do when a limit is exceeded, the default is [LimitThrottle].
+	Action LimitAction
+}
+
+// inboundLimiter tracks the [InboundLimitConfig] of a single connection,
+// it's only used by the reader.
+type inboundLimiter struct {
+	action LimitAction
+
+	messages, bytes, control *tokenBucket
+}
+
+func newInboundLimiter(cfg InboundLimitConfig) *inboundLimiter {
+	l := &inboundLimiter{action: cfg.Action}
+	if cfg.MessagesPerSecond > 0 {
+		b := newTokenBucket(cfg.MessagesPerSecond, cfg.MessagesBurst)
+		l.messages = &b
+	}
+	if cfg.BytesPerSecond > 0 {
+		b := newTokenBucket(cfg.BytesPerSecond, cfg.BytesBurst)
+		l.bytes = &b
+	}
+	if cfg.ControlFramesPerSecond > 0 {
+		b := newTokenBucket(cfg.ControlFramesPerSecond, cfg.ControlFramesBurst)
+		l.control = &b
+	}
+	if l.messages == nil && l.bytes == nil && l.control == nil {
+		return nil
+	}
+	return l
+}
+
+// allowFrame charges the frame to the limits before its payload is read, isFirst is
+// true for the first frame of a message, it returns false if the frame exceeded the limits.
+// Throttling sleeps here so the peer is slowed down by TCP flow control.
+func (l *inboundLimiter) allowFrame(h *Headers, isFirst bool) bool {
+	// the close handshake is never limited
+	if h.Opcode == CloseFrame {
+		return true
+	}
+	now := time.Now()
+	if isControlFrame(h.Opcode) {
+		return l.charge(l.control, now, 1)
+	}
+
+	ok := true
+	if isFirst {
+		ok = l.charge(l.messages, now, 1)
+	}
+	if h.PayloadLength > 0 {
+		ok = l.charge(l.bytes, now, float64(h.PayloadLength)) && ok
+	}
+	return ok
+}
+
+func (l *inboundLimiter) charge(b *tokenBucket, now time.Time, n float64) bool {
+	if b == nil {
+		return true
+	}
+	if l.action == LimitThrottle {
+		if wait := b.reserve(now, n); wait > 0 {
+			time.Sleep(wait)
+		}
+		return true
+	}
+	ok, _ := b.take(now, n)
+	return ok
+}
@@ -0,0 +1,222 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrDraining = errors.New("websocket: registry is draining")
+
+// Registry tracks live connections so they can be looked up by tag
+// and closed gracefully on shutdown, net/http forgets connections once they're hijacked.
+//
+// Connections upgraded by an [Upgrader] with the Registry field are registered automatically,
+// other connections can be registered with [Registry.Register].
+// Connections are removed from the registry once they're closed.
+type Registry struct {
+	mu    sync.Mutex
+	conns map[*Conn]map[string]struct{}
+	tags  map[string]map[*Conn]struct{}
+
+	draining bool
+	// closeCode is sent to connections registered while shutting down
+	closeCode uint16
+	shutdown  bool
+
+	// changed is closed and replaced whenever a connection is removed
+	changed chan struct{}
+}
+
+// Register adds c to the registry with the given tags,
+// it returns [ErrDraining] if the registry is draining.
+func (reg *Registry) Register(c *Conn, tags ...string) error {
+	reg.mu.Lock()
+	if reg.draining {
+		reg.mu.Unlock()
+		return ErrDraining
+	}
+	reg.addLocked(c, tags)
+	reg.mu.Unlock()
+
+	c.addOnClose(func() { reg.remove(c) })
+	return nil
+}
+
+// add registers a connection that was already accepted,
+// it's closed right away if the registry is shutting down.
+func (reg *Registry) add(c *Conn) {
+	reg.mu.Lock()
+	reg.addLocked(c, nil)
+	shutdown, code := reg.shutdown, reg.closeCode
+	reg.mu.Unlock()
+
+	c.addOnClose(func() { reg.remove(c) })
+	if shutdown {
+		go c.startClose(code)
+	}
+}
+
+func (reg *Registry) addLocked(c *Conn, tags []string) {
+	if reg.conns == nil {
+		reg.conns = make(map[*Conn]map[string]struct{})
+		reg.tags = make(map[string]map[*Conn]struct{})
+	}
+	if reg.conns[c] == nil {
+		reg.conns[c] = make(map[string]struct{})
+	}
+	reg.tagLocked(c, tags)
+}
+
+func (reg *Registry) tagLocked(c *Conn, tags []string) {
+	connTags, ok := reg.conns[c]
+	if !ok {
+		return
+	}
+	for _, tag := range tags {
+		connTags[tag] = struct{}{}
+		if reg.tags[tag] == nil {
+			reg.tags[tag] = make(map[*Conn]struct{})
+		}
+		reg.tags[tag][c] = struct{}{}
+	}
+}
+
+func (reg *Registry) remove(c *Conn) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	connTags, ok := reg.conns[c]
+	if !ok {
+		return
+	}
+	delete(reg.conns, c)
+	for tag := range connTags {
+		reg.untagLocked(c, tag)
+	}
+
+	if reg.changed != nil {
+		close(reg.changed)
+		reg.changed = nil
+	}
+}
+
+func (reg *Registry) untagLocked(c *Conn, tag string) {
+	delete(reg.tags[tag], c)
+	if len(reg.tags[tag]) == 0 {
+		delete(reg.tags, tag)
+	}
+}
+
+// Tag adds tags to a registered connection, like a user ID or a room name.
+func (reg *Registry) Tag(c *Conn, tags ...string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.tagLocked(c, tags)
+}
+
+// Untag removes tags from a registered connection.
+func (reg *Registry) Untag(c *Conn, tags ...string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	connTags, ok := reg.conns[c]
+	if !ok {
+		return
+	}
+	for _, tag := range tags {
+		delete(connTags, tag)
+		reg.untagLocked(c, tag)
+	}
+}
+
+// Lookup returns the connections with the given tag.
+func (reg *Registry) Lookup(tag string) []*Conn {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	conns := make([]*Conn, 0, len(reg.tags[tag]))
+	for c := range reg.tags[tag] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Conns returns all the registered connections.
+func (reg *Registry) Conns() []*Conn {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	conns := make([]*Conn, 0, len(reg.conns))
+	for c := range reg.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Len returns the number of registered connections.
+func (reg *Registry) Len() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return len(reg.conns)
+}
+
+// Drain makes the registry refuse new connections while the existing ones finish,
+// upgrades are rejected with 503 Service Unavailable.
+func (reg *Registry) Drain() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.draining = true
+}
+
+// IsDraining reports whether the registry refuses new connections.
+func (reg *Registry) IsDraining() bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.draining
+}
+
+// Shutdown drains the registry, sends every connection a close frame with code
+// (usually [CloseGoingAway] or [CloseServiceRestart]) and waits for the close handshakes.
+//
+// The close handshake completes when the peer's reply is read, so the connections MUST
+// still be read by [Conn.NextMessage] or a [Poller].
+// If ctx is done first the remaining connections are closed and ctx.Err() is returned.
+func (reg *Registry) Shutdown(ctx context.Context, code uint16) error {
+	reg.mu.Lock()
+	reg.draining = true
+	reg.shutdown = true
+	reg.closeCode = code
+	conns := make([]*Conn, 0, len(reg.conns))
+	for c := range reg.conns {
+		conns = append(conns, c)
+	}
+	reg.mu.Unlock()
+
+	// writes to slow peers block, don't wait for them
+	for _, c := range conns {
+		go c.startClose(code)
+	}
+
+	for {
+		reg.mu.Lock()
+		if len(reg.conns) == 0 {
+			reg.mu.Unlock()
+			return nil
+		}
+		if reg.changed == nil {
+			reg.changed = make(chan struct{})
+		}
+		changed := reg.changed
+		reg.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			for _, c := range reg.Conns() {
+				c.closeConn()
+			}
+			return ctx.Err()
+		}
+	}
+}
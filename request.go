@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrMalformedRequest      = errors.New("websocket: malformed handshake request")
+	ErrRequestHeaderTooLarge = errors.New("websocket: handshake request header too large")
+)
+
+// defaultMaxHeaderBytes is the default limit of the handshake request line and headers.
+const defaultMaxHeaderBytes = 8 << 10
+
+// readRequest reads the handshake request line and headers from br as defined in RFC 7230 section 3,
+// it only supports what a websocket handshake needs and never reads a body.
+func readRequest(br *bufio.Reader, maxHeaderBytes int) (*http.Request, error) {
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultMaxHeaderBytes
+	}
+	remaining := maxHeaderBytes
+
+	line, err := readLine(br, &remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	// request-line = method SP request-target SP HTTP-version
+	method, rest, ok1 := strings.Cut(line, " ")
+	target, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || !isToken(method) || target == "" {
+		return nil, ErrMalformedRequest
+	}
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	// the handshake MUST be at least HTTP/1.1
+	if !ok || major != 1 || minor < 1 {
+		return nil, ErrMalformedRequest
+	}
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, ErrMalformedRequest
+	}
+
+	header := make(http.Header)
+	for {
+		line, err := readLine(br, &remaining)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+
+		// header-field = field-name ":" OWS field-value OWS
+		name, value, ok := strings.Cut(line, ":")
+		// obsolete line folding starts with whitespace and is rejected with the rest
+		if !ok || !isToken(name) || !isFieldValue(value) {
+			return nil, ErrMalformedRequest
+		}
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		header[key] = append(header[key], strings.Trim(value, " \t"))
+	}
+
+	// a HTTP/1.1 request MUST have exactly one Host header
+	hosts := header.Values("Host")
+	if len(hosts) != 1 {
+		return nil, ErrMalformedRequest
+	}
+	header.Del("Host")
+	host := hosts[0]
+	if u.Host != "" {
+		host = u.Host
+	}
+
+	return &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     header,
+		Body:       http.NoBody,
+		Host:       host,
+		RequestURI: target,
+	}, nil
+}
+
+// readLine reads a single line without the line ending,
+// the line length is deducted from remaining.
+func readLine(br *bufio.Reader, remaining *int) (string, error) {
+	var line []byte
+	for {
+		b, err := br.ReadSlice('\n')
+		*remaining -= len(b)
+		if *remaining < 0 {
+			return "", ErrRequestHeaderTooLarge
+		}
+		line = append(line, b...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	// a bare LF is accepted as a line terminator as recommended by RFC 7230 section 3.5
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	return string(line), nil
+}
+
+// isFieldValue checks that s has no control characters other than horizontal tab.
+func isFieldValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var ErrServerClosed = errors.New("websocket: server closed")
+
+// Server is a lightweight websocket server that accepts connections directly
+// without net/http, only the HTTP/1.1 handshake request is parsed.
+//
+// Every accepted connection is upgraded with [Upgrader.UpgradeConn] validation
+// and passed to the Handler, requests that aren't valid handshakes are rejected.
+type Server struct {
+	// Upgrader used to validate the handshake, if not assigned the default [Upgrader] is used.
+	Upgrader *Upgrader
+
+	// Handler is called in its own goroutine for every upgraded connection,
+	// the connection is owned by the handler and is not closed when it returns.
+	// If the handler panics the connection is closed with [CloseInternalServerErr].
+	Handler func(c *Conn, r *http.Request)
+
+	// MaxHeaderBytes is the maximum size of the handshake request line and headers,
+	// if not assigned the default is 8KB.
+	MaxHeaderBytes int
+
+	// HandshakeTimeout is the maximum duration for reading the request and writing the response,
+	// if not assigned the default is 10 seconds.
+	HandshakeTimeout time.Duration
+
+	// ErrorLog is called with handshake and accept errors and handler panics,
+	// if not assigned errors are ignored.
+	ErrorLog func(err error)
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+// ListenAndServe listens on the TCP address addr and calls [Server.Serve].
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and upgrades them in a new goroutine each,
+// it always returns a non-nil error and closes l, after [Server.Close] the error is [ErrServerClosed].
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+	defer l.Close()
+
+	var delay time.Duration
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			// back off on temporary errors like running out of file descriptors
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay = min(2*delay, time.Second)
+				}
+				s.logError(err)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		go s.serveConn(netConn)
+	}
+}
+
+// Close closes all the listeners, upgraded connections are not affected.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	clear(s.listeners)
+	return err
+}
+
+func (s *Server) serveConn(netConn net.Conn) {
+	u := s.Upgrader
+	if u == nil {
+		u = &Upgrader{}
+	}
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	maxHeaderBytes := s.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultMaxHeaderBytes
+	}
+
+	netConn.SetDeadline(time.Now().Add(timeout))
+	c, r, err := u.upgradeConn(netConn, nil, maxHeaderBytes)
+	if err != nil {
+		s.logError(err)
+		return
+	}
+	netConn.SetDeadline(time.Time{})
+
+	if s.Handler == nil {
+		c.Close()
+		return
+	}
+
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v != http.ErrAbortHandler {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			s.logError(fmt.Errorf("websocket: panic serving %s: %v\n%s", netConn.RemoteAddr(), v, buf))
+		}
+		c.sendControl(CloseFrame, CloseInternalServerErr, nil)
+		c.closeConn()
+	}()
+
+	s.Handler(c, r)
+}
+
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) logError(err error) {
+	if s.ErrorLog != nil {
+		s.ErrorLog(err)
+	}
+}
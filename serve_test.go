@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerHandlerPanic(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	s := &Server{
+		Handler:  func(c *Conn, r *http.Request) { panic("boom") },
+		ErrorLog: func(err error) { errs <- err },
+	}
+	go s.Serve(ln)
+	defer s.Close()
+
+	netConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	if _, err := netConn.Write([]byte(testHandshake)); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(netConn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	op, payload := readServerFrame(t, br)
+	if op != CloseFrame || len(payload) < 2 || binary.BigEndian.Uint16(payload) != CloseInternalServerErr {
+		t.Fatalf("got %v %v, want a close frame with %d", op, payload, CloseInternalServerErr)
+	}
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "panic serving") || !strings.Contains(err.Error(), "boom") {
+			t.Fatalf("logged %q, want the panic", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic wasn't logged")
+	}
+}
@@ -2,12 +2,22 @@ package websocket
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"net/url"
 	"slices"
+	"strings"
+	"time"
 )
 
+// ErrHandshakeWrite is returned when writing the handshake response fails after the connection
+// was hijacked, no http response can be written to the request then.
+var ErrHandshakeWrite = errors.New("websocket: error writing the handshake response")
+
 // The Upgrader used to validate the handshake
 // and upgrade the connection.
 type Upgrader struct {
@@ -15,40 +25,81 @@ type Upgrader struct {
 	// if not assigned the default buffer size is 4KB.
 	ReadBufferSize int
 
+	// WriteBufferSize enables coalescing frames into a single write when assigned,
+	// buffered frames are sent on [Conn.Flush], when the buffer is full or after WriteFlushInterval.
+	// Control frames are always written immediately.
+	WriteBufferSize int
+
+	// WriteFlushInterval is the maximum time a frame stays in the write buffer,
+	// if not assigned frames are only sent when the buffer is full or on [Conn.Flush].
+	WriteFlushInterval time.Duration
+
 	// The fuction used to validate request origin.
 	// Check the origin carefully to prevent cross-site request forgery.
 	CheckOrigin func(r *http.Request) bool
 
+	// AllowedOrigins is used instead of the same origin check when CheckOrigin is not assigned,
+	// a pattern is a serialized origin like "https://example.com" or "http://localhost:8080",
+	// a leading "*." in the host matches any subdomain like "https://*.example.com".
+	// Default ports are implied and hosts are compared lowercase in punycode,
+	// the "null" origin and origins with userinfo are always rejected.
+	AllowedOrigins []string
+
+	// CSRFCookie enables the double submit CSRF check when assigned,
+	// the value of the cookie with this name MUST equal the CSRFParam query parameter.
+	CSRFCookie string
+
+	// CSRFParam is the query parameter holding the CSRF token,
+	// if not assigned the default is "csrf_token".
+	CSRFParam string
+
 	// Subprotocols is the server's supported protocols in order of prefernce.
 	// if no Subprotocols is specified then no protocol is negotiated during handshake.
 	Subprotocols []string
 
+	// SelectSubprotocol is used instead of Subprotocols to select the subprotocol,
+	// it receives the protocols offered by the client and returns the selected one
+	// or an empty string to not negotiate any protocol.
+	// Returning a protocol the client didn't offer is the same as returning an empty string.
+	SelectSubprotocol func(r *http.Request, offered []string) string
+
+	// Error is used by [Upgrader.Upgrade] to respond to a failed handshake,
+	// if not assigned a plain text response with the status text is sent.
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+
 	// enableCompression is wether to negotiate per-message deflate extension or not.
 	CompressionConfig CompressionConfig
-}
 
-// checkSameOrigin checks if the origin matchs the host.
-// returns True if no origin header was found, it's implied in this case
-// that the request was not made from a browser.
-func checkSameOrigin(r *http.Request) bool {
-	origin := r.Header["Origin"]
-	if len(origin) == 0 {
-		// No origin header so we can assume the client is not a browser.
-		return true
-	}
-	// parse host from origin and make sure it's valid
-	u, err := url.Parse(origin[0])
-	if err != nil {
-		return false
-	}
+	// SendQueue configures the queue used by [Conn.Enqueue].
+	SendQueue SendQueueConfig
 
-	return u.Host == r.Host
+	// InboundLimit limits the rate of messages and frames received from the peer.
+	InboundLimit InboundLimitConfig
+
+	// Admission limits the accepted connections and handshakes, rejected handshakes
+	// fail with [*AdmissionError] and the response has a Retry-After header.
+	Admission AdmissionConfig
+
+	// Registry tracks the upgraded connections when assigned,
+	// upgrades are rejected with 503 Service Unavailable while it's draining.
+	Registry *Registry
+
+	admission admission
 }
 
 // selectSubprotocol selects a subprotocols from the specified Subprotocols
-func (u *Upgrader) selectSubprotocol(headers http.Header) string {
-	values := headers.Values("Sec-WebSocket-Protocol")
-	subprotocols := splitHeaderValuesBySpace(values)
+// or using the SelectSubprotocol hook if assigned.
+func (u *Upgrader) selectSubprotocol(r *http.Request) string {
+	// malformed list elements are ignored
+	subprotocols, _ := parseTokenList(r.Header.Values("Sec-WebSocket-Protocol"))
+
+	if u.SelectSubprotocol != nil {
+		protocol := u.SelectSubprotocol(r, subprotocols)
+		if slices.Contains(subprotocols, protocol) {
+			return protocol
+		}
+		return ""
+	}
 
 	for _, protocol := range subprotocols {
 		if slices.Contains(u.Subprotocols, protocol) {
@@ -64,15 +115,30 @@ func (u *Upgrader) selectSubprotocol(headers http.Header) string {
 //
 // If the handshake was sucessful [Upgrade] returns [*Conn] represnting a websocket connection.
 //
+// responseHeader is included in the successful response, use it to set cookies (Set-Cookie) or any other
+// headers, responseHeader MUST NOT include any websocket reserved headers.
+//
 // If you want to handle responsing to the http request yourself when the handshake fails
-// see [Upgrader.UpgradeNoResponse]
+// see [Upgrader.UpgradeNoResponse] or assign the Error hook.
 //
 // Note that any authenication should be handled before upgrading the connection.
-func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
-	ws, code, err := u.upgradeConnection(w, r)
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	ws, code, err := u.upgradeConnection(w, r, responseHeader)
+	if errors.Is(err, ErrHandshakeWrite) {
+		// the connection was hijacked
+		return nil, err
+	}
 	if err != nil {
 		w.Header().Set("Sec-Websocket-Version", VERSION)
-		http.Error(w, http.StatusText(code), code)
+		var aerr *AdmissionError
+		if errors.As(err, &aerr) {
+			w.Header().Set("Retry-After", aerr.retryAfter())
+		}
+		if u.Error != nil {
+			u.Error(w, r, code, err)
+		} else {
+			http.Error(w, http.StatusText(code), code)
+		}
 		return nil, err
 	}
 
@@ -81,8 +147,9 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 
 // UpgradeNoResponse is same as [Upgrader.Upgrade] except it doesn't responed to the http request
 // and just returns the recommend http request to responed with.
-func (u *Upgrader) UpgradeNoResponse(w http.ResponseWriter, r *http.Request) (*Conn, int, error) {
-	ws, code, err := u.upgradeConnection(w, r)
+// If the error is [ErrHandshakeWrite] the connection was already hijacked so don't respond.
+func (u *Upgrader) UpgradeNoResponse(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, int, error) {
+	ws, code, err := u.upgradeConnection(w, r, responseHeader)
 	if err != nil {
 		return nil, code, err
 	}
@@ -90,8 +157,25 @@ func (u *Upgrader) UpgradeNoResponse(w http.ResponseWriter, r *http.Request) (*C
 	return ws, code, nil
 }
 
-// TODO: add docs
-func (u *Upgrader) upgradeConnection(w http.ResponseWriter, r *http.Request) (*Conn, int, error) {
+// serverHandshake holds the negotiated parameters of a valid handshake request.
+type serverHandshake struct {
+	acceptKey   string
+	subprotocol string
+
+	cc                                     *CompressionConfig
+	isFlate                                bool
+	isServerNoTakeover, isClientNoTakeover bool
+
+	// extensions is the extensions in the response
+	extensions []Extension
+
+	// release frees the admission slot
+	release func()
+}
+
+// negotiate validates the handshake request and negotiates the subprotocol and extensions,
+// it returns the recommended http status code if the request is invalid.
+func (u *Upgrader) negotiate(r *http.Request) (*serverHandshake, int, error) {
 	// Reject methods other than GET
 	if r.Method != http.MethodGet {
 		return nil, http.StatusBadRequest, fmt.Errorf("websocket: method not allowed: %s", r.Method)
@@ -115,15 +199,29 @@ func (u *Upgrader) upgradeConnection(w http.ResponseWriter, r *http.Request) (*C
 
 	// Do origin check
 	var originAllowed bool
-	if u.CheckOrigin == nil {
-		originAllowed = checkSameOrigin(r)
-	} else {
+	switch {
+	case u.CheckOrigin != nil:
 		originAllowed = u.CheckOrigin(r)
+	case len(u.AllowedOrigins) > 0:
+		originAllowed = checkAllowedOrigins(r, u.AllowedOrigins)
+	default:
+		originAllowed = checkSameOrigin(r)
 	}
 	if !originAllowed {
 		return nil, http.StatusBadRequest, fmt.Errorf("websocket: client failed Upgrader.CheckOrigin method")
 	}
 
+	// CSRF double submit check
+	if u.CSRFCookie != "" {
+		param := u.CSRFParam
+		if param == "" {
+			param = "csrf_token"
+		}
+		if !checkCSRFToken(r, u.CSRFCookie, param) {
+			return nil, http.StatusForbidden, ErrCSRFToken
+		}
+	}
+
 	// Challange key
 	key := r.Header.Get("Sec-WebSocket-Key")
 	// No challange key
@@ -133,74 +231,216 @@ func (u *Upgrader) upgradeConnection(w http.ResponseWriter, r *http.Request) (*C
 	if !isValidKey(key) {
 		return nil, http.StatusBadRequest, fmt.Errorf("websocket: invalid challange key value")
 	}
-	// generate new kay hash
-	newKey := makeKeyHash(key)
 
-	// Select a subprotocol (if exists)
-	subprotocol := u.selectSubprotocol(r.Header)
+	hs := &serverHandshake{
+		// generate new kay hash
+		acceptKey: makeKeyHash(key),
+		// Select a subprotocol (if exists)
+		subprotocol: u.selectSubprotocol(r),
+	}
 
-	exts := parseExtHeader(r.Header)
-	isFlate, isServerNoTakeover, isClientNoTakeover := isFlateIsTakeover(exts)
-	cc := &CompressionConfig{
-		Enabled:              u.CompressionConfig.Enabled,
+	// a malformed header only declines the extensions after the malformed value
+	exts, _ := parseExtHeader(r.Header)
+	hs.isFlate, hs.isServerNoTakeover, hs.isClientNoTakeover = isFlateIsTakeover(exts, false)
+	hs.cc = &CompressionConfig{
+		Enabled:              u.CompressionConfig.Enabled && hs.isFlate,
 		IsContextTakeover:    u.CompressionConfig.IsContextTakeover,
 		CompressionLevel:     u.CompressionConfig.CompressionLevel,
 		CompressionThreshold: u.CompressionConfig.CompressionThreshold,
 	}
-	if u.CompressionConfig.Enabled && isServerNoTakeover {
-		cc.IsContextTakeover = false
+	if u.CompressionConfig.Enabled && hs.isServerNoTakeover {
+		hs.cc.IsContextTakeover = false
+	}
+	if hs.cc.Enabled {
+		// same parameters as the response
+		h := make(http.Header)
+		h.Set("Sec-WebSocket-Extensions", strings.TrimSuffix(makeFlateExtHeader(hs.isServerNoTakeover, hs.isClientNoTakeover), "\r\n"))
+		negotiated, _ := parseExtHeader(h)
+		hs.extensions = toExtensions(negotiated)
 	}
 
-	// Hijack connection
-	netConn, _, err := http.NewResponseController(w).Hijack()
-	if err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("websocket: error while hijacking: %s", err)
+	if u.Registry != nil && u.Registry.IsDraining() {
+		return nil, http.StatusServiceUnavailable, ErrDraining
 	}
-	// Clean connection if error happens
-	defer func() {
-		if netConn != nil {
-			// Safe because we set the netConn variable to nil before returning.
-			netConn.Close()
+
+	// admission control is last so only valid handshakes take a slot
+	hs.release = func() {}
+	if u.Admission.isEnabled() {
+		release, err := u.admission.admit(&u.Admission, r)
+		if err != nil {
+			var aerr *AdmissionError
+			if errors.As(err, &aerr) {
+				return nil, aerr.StatusCode, err
+			}
+			return nil, http.StatusBadRequest, err
 		}
-	}()
+		hs.release = release
+	}
+
+	return hs, http.StatusSwitchingProtocols, nil
+}
 
-	// Build handshake
+// response builds the 101 handshake response.
+func (hs *serverHandshake) response(responseHeader http.Header) []byte {
 	handshake := make([]byte, 0)
 	// Protocol resourse and success code
 	handshake = append(handshake, "HTTP/1.1 101 Switching Protocols\r\n"...)
 	// Required headers
 	handshake = append(handshake, "Upgrade: websocket\r\nConnection: Upgrade\r\n"...)
 	// Challange key
-	handshake = append(handshake, fmt.Sprintf("Sec-WebSocket-Accept: %s\r\n", newKey)...)
+	handshake = append(handshake, fmt.Sprintf("Sec-WebSocket-Accept: %s\r\n", hs.acceptKey)...)
 	// selected subprotocol
-	if subprotocol != "" {
-		handshake = append(handshake, fmt.Sprintf("Sec-WebSocket-Protocol: %s\r\n", subprotocol)...)
+	if hs.subprotocol != "" {
+		handshake = append(handshake, fmt.Sprintf("Sec-WebSocket-Protocol: %s\r\n", hs.subprotocol)...)
 	}
-	if u.CompressionConfig.Enabled && isFlate {
-		ext := makeFlateExtHeader(isServerNoTakeover, isClientNoTakeover)
+	if hs.cc.Enabled {
+		ext := makeFlateExtHeader(hs.isServerNoTakeover, hs.isClientNoTakeover)
 		handshake = append(handshake, "Sec-WebSocket-Extensions: "+ext...)
-	} else {
-		cc.Enabled = false
+	}
+
+	// user headers
+	if responseHeader != nil {
+		var b bytes.Buffer
+		_ = responseHeader.Write(&b)
+		handshake = append(handshake, b.Bytes()...)
 	}
 
 	// Required empty line
 	handshake = append(handshake, "\r\n"...)
+	return handshake
+}
+
+// errorResponse builds a plain text http response for a failed handshake.
+func errorResponse(code int, err error) []byte {
+	text := http.StatusText(code)
+	res := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n"+
+		"Sec-WebSocket-Version: %s\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Length: %d\r\n"+
+		"Connection: close\r\n", code, text, VERSION, len(text)+1)
+	var aerr *AdmissionError
+	if errors.As(err, &aerr) {
+		res = fmt.Appendf(res, "Retry-After: %s\r\n", aerr.retryAfter())
+	}
+	return fmt.Appendf(res, "\r\n%s\n", text)
+}
+
+// newServerConn creates the server [*Conn] after the handshake response was written,
+// buffered holds any bytes read after the handshake request r.
+func (u *Upgrader) newServerConn(netConn net.Conn, buffered []byte, hs *serverHandshake, r *http.Request) *Conn {
+	br := newBufioReader(buffered, netConn, u.ReadBufferSize)
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = context.WithoutCancel(r.Context())
+	}
+	conn := newConn(ctx, netConn, br, hs.cc, hs.subprotocol, true)
+	conn.sendQueueConfig = u.SendQueue
+	conn.limiter = newInboundLimiter(u.InboundLimit)
+	conn.enableWriteBuffer(u.WriteBufferSize, u.WriteFlushInterval)
+	conn.extensions = hs.extensions
+	conn.request = r
+	conn.addOnClose(hs.release)
+	if u.Registry != nil {
+		u.Registry.add(conn)
+	}
+	return conn
+}
+
+// checkResponseHeader makes sure responseHeader doesn't override the handshake headers.
+func checkResponseHeader(responseHeader http.Header) error {
+	if responseHeader != nil && checkDuplicateHeaders(responseHeader, []string{
+		"Upgrade",
+		"Connection",
+		"Sec-WebSocket-Accept",
+		"Sec-WebSocket-Version",
+		"Sec-WebSocket-Extensions",
+		"Sec-WebSocket-Protocol",
+	}) {
+		return ErrDuplicateHeaders
+	}
+	return nil
+}
+
+// upgradeConnection validates the handshake request, hijacks the connection
+// and writes the handshake response, it returns the recommended http status code on failure.
+func (u *Upgrader) upgradeConnection(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, int, error) {
+	err := checkResponseHeader(responseHeader)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	hs, code, err := u.negotiate(r)
+	if err != nil {
+		return nil, code, err
+	}
+
+	// Hijack connection
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		hs.release()
+		return nil, http.StatusInternalServerError, fmt.Errorf("websocket: error while hijacking: %s", err)
+	}
 
 	// Write handshake directly
-	netConn.Write(handshake)
+	_, err = netConn.Write(hs.response(responseHeader))
+	if err != nil {
+		hs.release()
+		netConn.Close()
+		return nil, http.StatusInternalServerError, fmt.Errorf("%w: %w", ErrHandshakeWrite, err)
+	}
+
+	// net/http might have already buffered frames the client
+	// sent right after the handshake, keep them for the connection.
+	buffered, _ := brw.Reader.Peek(brw.Reader.Buffered())
+	return u.newServerConn(netConn, buffered, hs, r), http.StatusSwitchingProtocols, nil
+}
+
+// UpgradeConn is same as [Upgrader.Upgrade] but for connections not served by net/http,
+// it reads the HTTP/1.1 handshake request from br (or netConn if br is nil) with a header limit of 8KB,
+// validates it and writes the handshake response.
+//
+// On success it returns [*Conn] and the parsed request, on failure an error response
+// is written and netConn is closed.
+func (u *Upgrader) UpgradeConn(netConn net.Conn, br *bufio.Reader) (*Conn, *http.Request, error) {
+	return u.upgradeConn(netConn, br, defaultMaxHeaderBytes)
+}
 
-	// create buffer reader
-	var br *bufio.Reader
-	if u.ReadBufferSize != 0 {
-		br = bufio.NewReaderSize(netConn, u.ReadBufferSize)
-	} else {
-		// default size is 4KB
-		br = bufio.NewReader(netConn)
+func (u *Upgrader) upgradeConn(netConn net.Conn, br *bufio.Reader, maxHeaderBytes int) (*Conn, *http.Request, error) {
+	if br == nil {
+		br = newBufioReader(nil, netConn, u.ReadBufferSize)
 	}
 
-	conn := newConn(netConn, br, cc, subprotocol, true)
+	r, err := readRequest(br, maxHeaderBytes)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, ErrRequestHeaderTooLarge) {
+			code = http.StatusRequestHeaderFieldsTooLarge
+		}
+		netConn.Write(errorResponse(code, err))
+		netConn.Close()
+		return nil, nil, err
+	}
+	r.RemoteAddr = netConn.RemoteAddr().String()
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		r.TLS = &state
+	}
+
+	hs, code, err := u.negotiate(r)
+	if err != nil {
+		netConn.Write(errorResponse(code, err))
+		netConn.Close()
+		return nil, r, err
+	}
+
+	_, err = netConn.Write(hs.response(nil))
+	if err != nil {
+		hs.release()
+		netConn.Close()
+		return nil, r, fmt.Errorf("%w: %w", ErrHandshakeWrite, err)
+	}
 
-	// Unset netConn
-	netConn = nil
-	return conn, http.StatusSwitchingProtocols, nil
+	buffered, _ := br.Peek(br.Buffered())
+	return u.newServerConn(netConn, buffered, hs, r), r, nil
 }
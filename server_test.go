@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHandshake = "GET / HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"\r\n"
+
+// clientFrame returns a masked single frame sent by a client.
+func clientFrame(op Opcode, payload string) []byte {
+	b := []byte{0x80 | byte(op)}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, 0x80|byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x80|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0x80|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	key := [4]byte{1, 2, 3, 4}
+	b = append(b, key[:]...)
+	for i := range len(payload) {
+		b = append(b, payload[i]^key[i%4])
+	}
+	return b
+}
+
+func TestUpgradePipelinedFrame(t *testing.T) {
+	tests := []struct {
+		name           string
+		readBufferSize int
+		payload        string
+	}{
+		{"default buffer", 0, "hello"},
+		{"buffer smaller than the pipelined bytes", 16, string(make([]byte, 1024))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type result struct {
+				payload []byte
+				err     error
+			}
+			results := make(chan result, 1)
+
+			u := &Upgrader{ReadBufferSize: tt.readBufferSize}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c, err := u.Upgrade(w, r, nil)
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				defer c.Close()
+				_, payload, err := c.NextMessage()
+				results <- result{payload, err}
+			}))
+			defer srv.Close()
+
+			netConn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer netConn.Close()
+
+			// the handshake and the first frame in the same write
+			msg := append([]byte(testHandshake), clientFrame(BinaryMessage, tt.payload)...)
+			if _, err := netConn.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(netConn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusSwitchingProtocols)
+			}
+
+			select {
+			case r := <-results:
+				if r.err != nil {
+					t.Fatal(r.err)
+				}
+				if string(r.payload) != tt.payload {
+					t.Fatalf("payload = %q, want %q", r.payload, tt.payload)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the pipelined frame")
+			}
+		})
+	}
+}
+
+// readServerFrame reads an unmasked frame sent by the server.
+func readServerFrame(t *testing.T, br *bufio.Reader) (Opcode, []byte) {
+	t.Helper()
+	var h [2]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			t.Fatal(err)
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			t.Fatal(err)
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return Opcode(h[0] & 0x0f), payload
+}
+
+// hijackRecorder is a ResponseWriter that hijacks to a connection failing every write.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	brw := bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn))
+	return w.conn, brw, nil
+}
+
+func TestUpgradeHandshakeWriteError(t *testing.T) {
+	server, client := net.Pipe()
+	// writes to a closed pipe fail
+	client.Close()
+
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(testHandshake)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &hijackRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server}
+
+	errorCalled := false
+	u := &Upgrader{
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			errorCalled = true
+		},
+	}
+	_, err = u.Upgrade(w, r, nil)
+	if !errors.Is(err, ErrHandshakeWrite) {
+		t.Fatalf("err = %v, want %v", err, ErrHandshakeWrite)
+	}
+	if errorCalled {
+		t.Fatal("Error hook called for a hijacked connection")
+	}
+	if w.Body.Len() != 0 || len(w.Header()) != 0 {
+		t.Fatalf("response written for a hijacked connection: %v %q", w.Header(), w.Body)
+	}
+}
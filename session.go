@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var errSessionPanic = errors.New("websocket: panic in session callback")
+
+// sessionCloseTimeout is how long a session waits for the peer to reply to its close frame.
+const sessionCloseTimeout = 5 * time.Second
+
+// Session runs the read loop of a [Conn] and calls its callbacks for the connection events,
+// messages sent with [Session.Send] are written by the connection's send queue.
+//
+// Callbacks are called from the goroutine running [Session.Run], so they're never called concurrently.
+// A panic in a callback is passed to OnError and the connection is closed with [CloseInternalServerErr].
+type Session struct {
+	// OnOpen is called once before the first message is read.
+	OnOpen func()
+
+	// OnMessage is called for every [TextMessage] and [BinaryMessage] received.
+	OnMessage func(mt Opcode, payload []byte)
+
+	// OnError is called when the connection fails, not when it's closed normally.
+	OnError func(err error)
+
+	// OnClose is called once the connection is closed with the status code and reason sent by the peer,
+	// or [CloseAbnormal] if the connection was lost without a close frame.
+	OnClose func(code uint16, reason string)
+
+	c *Conn
+
+	mu sync.Mutex
+	// closeCode is the status code sent by the session, 0 if it didn't close the connection.
+	closeCode uint16
+}
+
+// NewSession creates a [Session] for c, assign the callbacks then call [Session.Run].
+func NewSession(c *Conn) *Session {
+	return &Session{c: c}
+}
+
+// Conn returns the underlying connection.
+func (s *Session) Conn() *Conn {
+	return s.c
+}
+
+// Send queues a message to be written, it's safe to call from any goroutine.
+// See [Conn.Enqueue] for how a full queue is handled.
+func (s *Session) Send(payload []byte, mt Opcode) error {
+	_, err := s.c.Enqueue(payload, mt)
+	return err
+}
+
+// Close starts the close handshake with [CloseNormal], [Session.Run] returns once the peer replies.
+func (s *Session) Close() {
+	s.close(CloseNormal)
+}
+
+func (s *Session) close(code uint16) {
+	s.mu.Lock()
+	if s.closeCode != 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.closeCode = code
+	s.mu.Unlock()
+
+	// don't wait forever for a peer that doesn't reply
+	time.AfterFunc(sessionCloseTimeout, func() { s.c.closeConn() })
+	go s.c.startClose(code)
+}
+
+func (s *Session) sentCloseCode() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeCode
+}
+
+// Run reads from the connection and calls the callbacks until it's closed,
+// if ctx is done first the connection is closed with [CloseGoingAway].
+//
+// It returns nil if the connection was closed normally, otherwise the error that closed it.
+func (s *Session) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { s.close(CloseGoingAway) })
+	defer stop()
+	defer s.c.Close()
+
+	err := s.safeCall(func() {
+		if s.OnOpen != nil {
+			s.OnOpen()
+		}
+	})
+
+	for err == nil {
+		var mt Opcode
+		var payload []byte
+		mt, payload, err = s.c.NextMessage()
+		if err != nil {
+			break
+		}
+
+		err = s.safeCall(func() {
+			if s.OnMessage != nil {
+				s.OnMessage(mt, payload)
+			}
+		})
+	}
+
+	code, reason := CloseAbnormal, ""
+	var closeErr *CloseError
+	switch {
+	case errors.As(err, &closeErr):
+		code, reason = closeErr.Code, closeErr.Text
+		err = nil
+	case errors.Is(err, errSessionPanic):
+		code = CloseInternalServerErr
+		s.safeCall(func() {
+			if s.OnError != nil {
+				s.OnError(err)
+			}
+		})
+	case s.sentCloseCode() != 0:
+		// closed by the session without a reply from the peer
+		code = s.sentCloseCode()
+		err = nil
+	default:
+		s.safeCall(func() {
+			if s.OnError != nil {
+				s.OnError(err)
+			}
+		})
+	}
+
+	s.c.closeConn()
+	s.safeCall(func() {
+		if s.OnClose != nil {
+			s.OnClose(code, reason)
+		}
+	})
+	return err
+}
+
+// safeCall calls fn and recovers from panics, the connection is closed
+// with [CloseInternalServerErr] after a panic.
+func (s *Session) safeCall(fn func()) (err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		err = fmt.Errorf("%w: %v", errSessionPanic, v)
+		s.c.sendControl(CloseFrame, CloseInternalServerErr, nil)
+		s.c.closeConn()
+	}()
+
+	fn()
+	return nil
+}
@@ -1,9 +1,13 @@
 package websocket
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
+	"io"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -38,19 +42,21 @@ func checkHeaderValue(headers http.Header, h string, v string) bool {
 	return false
 }
 
-// TODO: add docs
-func splitHeaderValuesBySpace(strList []string) []string {
-	var splitted []string
-	for _, str := range strList {
-		parts := strings.Fields(str)
-
-		for _, s := range parts {
-			cleaned := strings.TrimSpace(s)
-			splitted = append(splitted, cleaned)
-		}
+// newBufioReader creates the connection's buffered reader with the given size (default 4KB),
+// the already buffered bytes are read first so they aren't lost.
+func newBufioReader(buffered []byte, netConn net.Conn, size int) *bufio.Reader {
+	if size <= 0 {
+		size = 4096
+	}
+	if len(buffered) == 0 {
+		return bufio.NewReaderSize(netConn, size)
 	}
 
-	return splitted
+	buffered = bytes.Clone(buffered)
+	br := bufio.NewReaderSize(io.MultiReader(bytes.NewReader(buffered), netConn), max(size, len(buffered)))
+	// pull the buffered bytes in right away, so every unread byte is in br
+	_, _ = br.Peek(len(buffered))
+	return br
 }
 
 // TODO: add docs
@@ -64,78 +70,17 @@ func checkDuplicateHeaders(headers http.Header, toCheck []string) bool {
 	return false
 }
 
-type extension struct {
-	name   string
-	params []string
-}
-
-func parseExtHeader(h http.Header) []extension {
-	exts := make([]extension, 0)
-
-	headerValue := h.Get("Sec-WebSocket-Extensions")
-	if headerValue == "" {
-		return exts
-	}
-
-	extsStr := strings.Split(headerValue, ",")
-	// trim space
-	for i := range extsStr {
-		extsStr[i] = strings.TrimSpace(extsStr[i])
-	}
-
-	for _, extStr := range extsStr {
-		extParams := strings.Split(extStr, ";")
-		// trim space
-		for i := range extParams {
-			extParams[i] = strings.TrimSpace(extParams[i])
-		}
-
-		name := extParams[0]
-		var ext extension
-		if len(extParams) == 1 {
-			ext = extension{
-				name: name,
-			}
-		} else {
-			ext = extension{
-				name:   name,
-				params: extParams[1:],
-			}
-		}
-
-		exts = append(exts, ext)
-	}
-
-	return exts
-}
-
-func isFlateIsTakeover(exts []extension) (bool, bool, bool) {
+func isFlateIsTakeover(exts []extension, isResponse bool) (bool, bool, bool) {
 	// check if defalte extension exits and if we're using context_takeover,
-	// we don't check for max bit cause we can't adjust defalte window.
-exts:
+	// offers with parameters we can't satisfy are skipped.
 	for _, ext := range exts {
-		isServerNoTakeover := false
-		isClientNoTakeover := false
-
 		if ext.name != "permessage-deflate" {
 			continue
 		}
 
-		for _, p := range ext.params {
-			switch {
-			case p == "client_no_context_takeover":
-				isServerNoTakeover = true
-				continue
-			case p == "server_no_context_takeover":
-				isClientNoTakeover = true
-				continue
-			case p == "server_max_window_bits=15" || p == "client_max_window_bits":
-				continue
-			case strings.HasPrefix(p, "client_max_window_bits="):
-				continue
-			default:
-				continue exts
-			}
+		isServerNoTakeover, isClientNoTakeover, ok := parseFlateParams(ext.params, isResponse)
+		if !ok {
+			continue
 		}
 		return true, isServerNoTakeover, isClientNoTakeover
 	}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestNewBufioReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		buffered string
+		size     int
+	}{
+		{"no buffered bytes", "", 16},
+		{"buffered fit the buffer", "hello", 16},
+		{"buffered bigger than the buffer", string(bytes.Repeat([]byte("a"), 64)), 16},
+		{"default size", "hello", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			go func() {
+				client.Write([]byte("world"))
+				client.Close()
+			}()
+
+			br := newBufioReader([]byte(tt.buffered), server, tt.size)
+			if br.Buffered() != len(tt.buffered) {
+				t.Fatalf("Buffered() = %d, want %d", br.Buffered(), len(tt.buffered))
+			}
+
+			got, err := io.ReadAll(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.buffered + "world"; string(got) != want {
+				t.Fatalf("read %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNewBufioReaderCopiesBuffered(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	buffered := []byte("hello")
+	br := newBufioReader(buffered, server, 16)
+	copy(buffered, "xxxxx")
+
+	got, _ := br.Peek(5)
+	if string(got) != "hello" {
+		t.Fatalf("Peek() = %q, want %q", got, "hello")
+	}
+}